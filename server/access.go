@@ -0,0 +1,243 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+const (
+	// accessRulesTTL is how long the access rules stored by /mu access are
+	// reused before they are read again, so that changes made on another
+	// node of a cluster apply within this time.
+	accessRulesTTL = 30 * time.Second
+
+	// principalCacheTTL is how long resolved roles and groups of a user are
+	// reused before they are looked up again.
+	principalCacheTTL = 5 * time.Minute
+	// principalCacheSize bounds the number of cached lookups.
+	principalCacheSize = 10000
+)
+
+// isAuthorized reports whether the bot may answer userID in channel: no block
+// of /mu access applies, the reply policy and the team, channel and user lists
+// allow it, and so do the role and group rules.
+func (p *Plugin) isAuthorized(cfg *Configuration, channel *model.Channel, userID string) bool {
+	rules := p.getAccessRules()
+	if accessRulesBlock(rules, channel, userID) {
+		return false
+	}
+	cfg = cfg.withAccessRules(rules)
+	if !cfg.canAnswer(channel, userID) {
+		return false
+	}
+
+	// users whose roles or groups cannot be resolved are refused
+	if len(cfg.RoleAllowNames)+len(cfg.RoleBlockNames) > 0 {
+		roles, appErr := p.userRoles(channel.TeamId, channel.Id, userID)
+		if appErr != nil {
+			logError(p, appErr, "cannot resolve user roles", "user_id", userID)
+			return false
+		}
+		if !principalsAllowed(roles, cfg.RoleAllowNames, cfg.RoleBlockNames) {
+			return false
+		}
+	}
+	if len(cfg.GroupAllowNames)+len(cfg.GroupBlockNames) > 0 {
+		groups, appErr := p.userGroups(userID)
+		if appErr != nil {
+			logError(p, appErr, "cannot resolve user groups", "user_id", userID)
+			return false
+		}
+		if !principalsAllowed(groups, cfg.GroupAllowNames, cfg.GroupBlockNames) {
+			return false
+		}
+	}
+	return true
+}
+
+// withAccessRules returns the configuration with the allows of /mu access
+// added to the team, channel and user allow lists of the System Console.
+func (c *Configuration) withAccessRules(rules *kvstore.AccessRules) *Configuration {
+	if rules == nil || len(rules.Teams.Allow)+len(rules.Channels.Allow)+len(rules.Users.Allow) == 0 {
+		return c
+	}
+	merged := c.Clone()
+	merged.TeamAllowIDs = append(merged.TeamAllowIDs, rules.Teams.Allow...)
+	merged.ChannelAllowIDs = append(merged.ChannelAllowIDs, rules.Channels.Allow...)
+	merged.UserAllowIDs = append(merged.UserAllowIDs, rules.Users.Allow...)
+	return merged
+}
+
+// accessRulesBlock reports whether /mu access blocks the team, the channel or
+// the user. Unlike the block lists of the System Console, these blocks apply
+// in every access mode.
+func accessRulesBlock(rules *kvstore.AccessRules, channel *model.Channel, userID string) bool {
+	if rules == nil {
+		return false
+	}
+	return (channel.TeamId != "" && contains(rules.Teams.Block, channel.TeamId)) ||
+		contains(rules.Channels.Block, channel.Id) ||
+		contains(rules.Users.Block, userID)
+}
+
+// getAccessRules returns the rules stored by /mu access, read from the KV
+// store at most every accessRulesTTL.
+func (p *Plugin) getAccessRules() *kvstore.AccessRules {
+	p.accessRulesLock.RLock()
+	rules, loadedAt := p.accessRules, p.accessRulesLoadedAt
+	p.accessRulesLock.RUnlock()
+	if rules != nil && time.Since(loadedAt) < accessRulesTTL {
+		return rules
+	}
+
+	fresh, err := p.kvstore.GetAccessRules()
+	if err != nil {
+		logError(p, err, "cannot load access rules")
+		return rules
+	}
+	p.setAccessRules(fresh)
+	return fresh
+}
+
+// setAccessRules replaces the cached access rules.
+func (p *Plugin) setAccessRules(rules *kvstore.AccessRules) {
+	p.accessRulesLock.Lock()
+	defer p.accessRulesLock.Unlock()
+	p.accessRules = rules
+	p.accessRulesLoadedAt = time.Now()
+}
+
+// principalsAllowed reports whether a user with the given roles or groups
+// passes the rules: none of them is blocked and, if there is an allow list,
+// at least one of them is on it.
+func principalsAllowed(have, allow, block []string) bool {
+	for _, name := range have {
+		if containsFold(block, name) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, name := range have {
+		if containsFold(allow, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// userRoles returns the system, team and channel roles of userID in a channel,
+// such as "system_admin", "team_admin" or "channel_guest". teamID is empty for
+// DMs and group messages.
+func (p *Plugin) userRoles(teamID, channelID, userID string) ([]string, *model.AppError) {
+	var roles []string
+
+	systemRoles, appErr := p.principals.resolve("user:"+userID, func() ([]string, *model.AppError) {
+		user, appErr := p.API.GetUser(userID)
+		if appErr != nil {
+			return nil, appErr
+		}
+		return strings.Fields(user.Roles), nil
+	})
+	if appErr != nil {
+		return nil, appErr
+	}
+	roles = append(roles, systemRoles...)
+
+	if teamID != "" {
+		teamRoles, appErr := p.principals.resolve("team:"+teamID+":"+userID, func() ([]string, *model.AppError) {
+			member, appErr := p.API.GetTeamMember(teamID, userID)
+			if appErr != nil {
+				return nil, appErr
+			}
+			return strings.Fields(member.Roles), nil
+		})
+		if appErr != nil {
+			return nil, appErr
+		}
+		roles = append(roles, teamRoles...)
+	}
+
+	channelRoles, appErr := p.principals.resolve("channel:"+channelID+":"+userID, func() ([]string, *model.AppError) {
+		member, appErr := p.API.GetChannelMember(channelID, userID)
+		if appErr != nil {
+			return nil, appErr
+		}
+		return strings.Fields(member.Roles), nil
+	})
+	if appErr != nil {
+		return nil, appErr
+	}
+	return append(roles, channelRoles...), nil
+}
+
+// userGroups returns the IDs, names and display names of the groups userID
+// belongs to, custom or synced from LDAP.
+func (p *Plugin) userGroups(userID string) ([]string, *model.AppError) {
+	return p.principals.resolve("groups:"+userID, func() ([]string, *model.AppError) {
+		groups, appErr := p.API.GetGroupsForUser(userID)
+		if appErr != nil {
+			return nil, appErr
+		}
+		var names []string
+		for _, group := range groups {
+			names = append(names, group.Id, group.DisplayName)
+			if group.Name != nil {
+				names = append(names, *group.Name)
+			}
+		}
+		return names, nil
+	})
+}
+
+// principalCache keeps resolved roles and groups for principalCacheTTL. The
+// zero value is ready to use.
+type principalCache struct {
+	mu      sync.Mutex
+	entries map[string]principalEntry
+}
+
+type principalEntry struct {
+	names   []string
+	expires time.Time
+}
+
+// resolve returns the cached names for key, calling lookup when there are
+// none or they expired. Failed lookups are not cached.
+func (c *principalCache) resolve(key string, lookup func() ([]string, *model.AppError)) ([]string, *model.AppError) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.names, nil
+	}
+
+	names, appErr := lookup()
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= principalCacheSize {
+		c.entries = make(map[string]principalEntry)
+	}
+	c.entries[key] = principalEntry{names: names, expires: now.Add(principalCacheTTL)}
+	return names, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// Subcommands of /mu access.
+const (
+	accessCommand = "access"
+	accessAllow   = "allow"
+	accessBlock   = "block"
+	accessRemove  = "remove"
+	accessList    = "list"
+)
+
+// isAccessCommand reports whether the words after /mu are an access
+// subcommand rather than a question.
+func isAccessCommand(words []string) bool {
+	if len(words) == 0 || words[0] != accessCommand {
+		return false
+	}
+	if len(words) == 1 {
+		return true
+	}
+	switch words[1] {
+	case accessAllow, accessBlock, accessRemove, accessList:
+		return true
+	default:
+		return false
+	}
+}
+
+// accessTarget is a team, channel or user named in /mu access.
+type accessTarget struct {
+	list  func(*kvstore.AccessRules) *kvstore.AccessList
+	mode  func(*Configuration) string
+	id    string
+	label string
+}
+
+// executeAccessCommand runs /mu access for a system admin: allow, block or
+// remove teams, ~channels and @users, or list the rules.
+func (p *Plugin) executeAccessCommand(args *model.CommandArgs, words []string) *model.CommandResponse {
+	locale := p.userLocale(args.UserId)
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		return ephemeralResponse(localize(locale, msgAccessAdminOnly))
+	}
+	if len(words) < 2 {
+		return ephemeralResponse(localize(locale, msgAccessUsage))
+	}
+
+	action, names := words[1], words[2:]
+	if action == accessList {
+		return ephemeralResponse(p.describeAccessRules(locale))
+	}
+	if len(names) == 0 {
+		return ephemeralResponse(localize(locale, msgAccessUsage))
+	}
+
+	var targets []accessTarget
+	for _, name := range names {
+		target, ok := p.resolveAccessTarget(args.TeamId, name)
+		if !ok {
+			return ephemeralResponse(fmt.Sprintf(localize(locale, msgAccessNotFound), name))
+		}
+		targets = append(targets, target)
+	}
+
+	// an allow only matters where the console lets selected IDs in; a block
+	// applies in every mode
+	if action == accessAllow {
+		cfg := p.getConfiguration()
+		for _, target := range targets {
+			if mode := accessModeName(target.mode(cfg)); mode != "allow_selected" {
+				return ephemeralResponse(fmt.Sprintf(localize(locale, msgAccessAllowIneffective), target.label, mode))
+			}
+		}
+	}
+
+	rules, err := p.kvstore.UpdateAccessRules(func(rules *kvstore.AccessRules) {
+		for _, target := range targets {
+			list := target.list(rules)
+			switch action {
+			case accessAllow:
+				list.Set(target.id, true)
+			case accessBlock:
+				list.Set(target.id, false)
+			case accessRemove:
+				list.Remove(target.id)
+			}
+		}
+	})
+	if err != nil {
+		logError(p, err, "cannot update access rules")
+		return ephemeralResponse(localize(locale, msgAccessFailed))
+	}
+	p.setAccessRules(rules)
+
+	labels := make([]string, 0, len(targets))
+	for _, target := range targets {
+		labels = append(labels, target.label)
+	}
+	p.API.LogInfo("Access rules changed", "user_id", args.UserId, "action", action, "targets", strings.Join(labels, " "))
+	done := map[string]string{accessAllow: msgAccessAllowed, accessBlock: msgAccessBlocked, accessRemove: msgAccessRemoved}[action]
+	return ephemeralResponse(fmt.Sprintf(localize(locale, done), strings.Join(labels, ", ")) +
+		"\n\n" + p.describeAccessRules(locale))
+}
+
+// resolveAccessTarget looks up "~channel" in teamID, "@username", or a team
+// name.
+func (p *Plugin) resolveAccessTarget(teamID, name string) (accessTarget, bool) {
+	switch {
+	case strings.HasPrefix(name, "~"):
+		channel, appErr := p.API.GetChannelByName(teamID, strings.ToLower(name[1:]), false)
+		if appErr != nil {
+			return accessTarget{}, false
+		}
+		return accessTarget{list: channelRules, mode: channelAccessMode, id: channel.Id, label: "~" + channel.Name}, true
+	case strings.HasPrefix(name, "@"):
+		user, appErr := p.API.GetUserByUsername(strings.ToLower(name[1:]))
+		if appErr != nil {
+			return accessTarget{}, false
+		}
+		return accessTarget{list: userRules, mode: userAccessMode, id: user.Id, label: "@" + user.Username}, true
+	default:
+		team, appErr := p.API.GetTeamByName(strings.ToLower(name))
+		if appErr != nil {
+			return accessTarget{}, false
+		}
+		return accessTarget{list: teamRules, mode: teamAccessMode, id: team.Id, label: team.Name}, true
+	}
+}
+
+func teamRules(r *kvstore.AccessRules) *kvstore.AccessList    { return &r.Teams }
+func channelRules(r *kvstore.AccessRules) *kvstore.AccessList { return &r.Channels }
+func userRules(r *kvstore.AccessRules) *kvstore.AccessList    { return &r.Users }
+
+func teamAccessMode(c *Configuration) string    { return c.TeamAccess }
+func channelAccessMode(c *Configuration) string { return c.ChannelAccess }
+func userAccessMode(c *Configuration) string    { return c.UserAccess }
+
+// accessModeName returns an access mode of the System Console, with the
+// default for an unset one.
+func accessModeName(mode string) string {
+	if mode == "" {
+		return "allow_all"
+	}
+	return mode
+}
+
+// describeAccessRules lists the rules managed with /mu access by name, with
+// the access modes of the System Console that decide which lists apply.
+func (p *Plugin) describeAccessRules(locale string) string {
+	rules, err := p.kvstore.GetAccessRules()
+	if err != nil {
+		logError(p, err, "cannot load access rules")
+		return localize(locale, msgAccessFailed)
+	}
+	p.setAccessRules(rules)
+
+	cfg := p.getConfiguration()
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(localize(locale, msgAccessModes),
+		accessModeName(cfg.TeamAccess), accessModeName(cfg.ChannelAccess), accessModeName(cfg.UserAccess)))
+	if rules.Empty() {
+		sb.WriteString("\n\n" + localize(locale, msgAccessEmpty))
+		return sb.String()
+	}
+
+	sb.WriteString("\n\n" + localize(locale, msgAccessTable))
+	for _, row := range []struct {
+		id    string
+		list  kvstore.AccessList
+		label func(string) string
+	}{
+		{msgAccessTeams, rules.Teams, p.teamLabel},
+		{msgAccessChannels, rules.Channels, p.channelLabel},
+		{msgAccessUsers, rules.Users, p.userLabel},
+	} {
+		sb.WriteString(fmt.Sprintf("\n| %s | %s | %s |", localize(locale, row.id),
+			joinLabels(row.list.Allow, row.label), joinLabels(row.list.Block, row.label)))
+	}
+	return sb.String()
+}
+
+func joinLabels(ids []string, label func(string) string) string {
+	labels := make([]string, 0, len(ids))
+	for _, id := range ids {
+		labels = append(labels, label(id))
+	}
+	return strings.Join(labels, ", ")
+}
+
+// teamLabel, channelLabel and userLabel name an ID for display, falling back
+// to the ID itself if it no longer exists.
+func (p *Plugin) teamLabel(id string) string {
+	if team, appErr := p.API.GetTeam(id); appErr == nil {
+		return team.Name
+	}
+	return id
+}
+
+func (p *Plugin) channelLabel(id string) string {
+	if channel, appErr := p.API.GetChannel(id); appErr == nil {
+		return "~" + channel.Name
+	}
+	return id
+}
+
+func (p *Plugin) userLabel(id string) string {
+	if user, appErr := p.API.GetUser(id); appErr == nil {
+		return "@" + user.Username
+	}
+	return id
+}
+
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIsAccessCommand(t *testing.T) {
+	assert.True(t, isAccessCommand([]string{"access"}))
+	assert.True(t, isAccessCommand([]string{"access", "list"}))
+	assert.True(t, isAccessCommand([]string{"access", "allow", "~town-square"}))
+	assert.False(t, isAccessCommand([]string{"access", "to", "the", "VPN?"}))
+	assert.False(t, isAccessCommand([]string{"what", "is", "access?"}))
+}
+
+func TestExecuteAccessCommand(t *testing.T) {
+	api := &plugintest.API{}
+	store := &rulesStore{}
+	p := &Plugin{kvstore: store}
+	p.SetAPI(api)
+	p.setConfiguration(&Configuration{})
+
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Locale: "en"}, nil)
+	api.On("GetUser", "member").Return(&model.User{Id: "member", Locale: "en"}, nil)
+	api.On("HasPermissionTo", "admin", model.PermissionManageSystem).Return(true)
+	api.On("HasPermissionTo", "member", model.PermissionManageSystem).Return(false)
+	api.On("GetChannelByName", "team", "support", false).Return(&model.Channel{Id: "c1", Name: "support"}, nil)
+	api.On("GetChannel", "c1").Return(&model.Channel{Id: "c1", Name: "support"}, nil)
+	api.On("GetUserByUsername", "bob").Return(&model.User{Id: "u1", Username: "bob"}, nil)
+	api.On("GetUser", "u1").Return(&model.User{Id: "u1", Username: "bob"}, nil)
+	api.On("GetTeamByName", "nope").Return(nil, model.NewAppError("GetTeamByName", "not_found", nil, "", 404))
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+
+	run := func(userID string, words ...string) string {
+		return p.executeAccessCommand(&model.CommandArgs{UserId: userID, TeamId: "team"}, words).Text
+	}
+
+	assert.Contains(t, run("member", "access", "allow", "~support"), "Only system admins")
+	assert.True(t, store.rules.Empty())
+
+	// an allow that would do nothing under the channel mode is refused
+	text := run("admin", "access", "allow", "~support")
+	assert.Contains(t, text, "~support was not allowed: its access mode is `allow_all`")
+	assert.True(t, store.rules.Empty())
+
+	p.setConfiguration(&Configuration{ChannelAccess: "allow_selected"})
+	text = run("admin", "access", "allow", "~support")
+	assert.Contains(t, text, "Allowed: ~support")
+	assert.Equal(t, []string{"c1"}, store.rules.Channels.Allow)
+
+	text = run("admin", "access", "block", "@bob")
+	assert.Contains(t, text, "| Users |  | @bob |")
+	assert.Equal(t, []string{"u1"}, store.rules.Users.Block)
+
+	assert.Contains(t, run("admin", "access", "allow", "nope"), `Could not find "nope"`)
+
+	run("admin", "access", "remove", "~support", "@bob")
+	assert.True(t, store.rules.Empty())
+	assert.Contains(t, run("admin", "access", "list"), "No rules")
+}
+
+func TestAccessCommandBlockUnderDefaultModes(t *testing.T) {
+	api := &plugintest.API{}
+	store := &rulesStore{}
+	p := &Plugin{kvstore: store}
+	p.SetAPI(api)
+	p.setConfiguration(&Configuration{})
+
+	api.On("GetUser", "admin").Return(&model.User{Id: "admin", Locale: "en"}, nil)
+	api.On("HasPermissionTo", "admin", model.PermissionManageSystem).Return(true)
+	api.On("GetChannelByName", "team", "random", false).Return(&model.Channel{Id: "c1", Name: "random"}, nil)
+	api.On("GetChannel", "c1").Return(&model.Channel{Id: "c1", Name: "random"}, nil)
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+
+	random := &model.Channel{Id: "c1", TeamId: "team", Type: model.ChannelTypeOpen}
+	cfg := p.getConfiguration()
+	assert.True(t, p.isAuthorized(cfg, random, "alice"))
+
+	text := p.executeAccessCommand(&model.CommandArgs{UserId: "admin", TeamId: "team"}, []string{"access", "block", "~random"}).Text
+	assert.Contains(t, text, "Blocked: ~random")
+	assert.False(t, p.isAuthorized(cfg, random, "alice"), "a block applies under allow_all")
+
+	// it also overrides an allow of the System Console
+	cfg = &Configuration{ChannelAccess: "allow_selected", ChannelAllowIDs: []string{"c1"}}
+	assert.False(t, p.isAuthorized(cfg, random, "alice"))
+}
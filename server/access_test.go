@@ -0,0 +1,106 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// rulesStore keeps the /mu access rules in memory; other KVStore methods are
+// not used.
+type rulesStore struct {
+	kvstore.KVStore
+	rules kvstore.AccessRules
+}
+
+func (s *rulesStore) GetAccessRules() (*kvstore.AccessRules, error) {
+	rules := s.rules
+	return &rules, nil
+}
+
+func (s *rulesStore) UpdateAccessRules(update func(*kvstore.AccessRules)) (*kvstore.AccessRules, error) {
+	update(&s.rules)
+	return s.GetAccessRules()
+}
+
+func TestPrincipalsAllowed(t *testing.T) {
+	assert.True(t, principalsAllowed([]string{"system_user"}, nil, nil))
+	assert.True(t, principalsAllowed([]string{"system_user", "team_admin"}, []string{"TEAM_ADMIN"}, nil))
+	assert.False(t, principalsAllowed([]string{"system_user"}, []string{"team_admin"}, nil))
+	assert.False(t, principalsAllowed([]string{"system_guest", "team_admin"}, []string{"team_admin"}, []string{"system_guest"}))
+	assert.False(t, principalsAllowed(nil, []string{"support"}, nil))
+}
+
+func TestIsAuthorizedByGroup(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	support := "support"
+	api.On("GetGroupsForUser", "alice").Return([]*model.Group{{Id: "g1", Name: &support, DisplayName: "Support"}}, nil).Once()
+	api.On("GetGroupsForUser", "bob").Return([]*model.Group{}, nil).Once()
+
+	p := &Plugin{kvstore: &rulesStore{}}
+	p.SetAPI(api)
+	cfg := &Configuration{GroupAllowNames: []string{"support"}}
+	channel := &model.Channel{Id: "channel", Type: model.ChannelTypeOpen}
+
+	assert.True(t, p.isAuthorized(cfg, channel, "alice"))
+	assert.False(t, p.isAuthorized(cfg, channel, "bob"))
+
+	// memberships are cached: no further lookups
+	assert.True(t, p.isAuthorized(cfg, channel, "alice"))
+	assert.False(t, p.isAuthorized(cfg, channel, "bob"))
+}
+
+func TestIsAuthorizedByRole(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	api.On("GetUser", "guest").Return(&model.User{Id: "guest", Roles: "system_guest"}, nil)
+	api.On("GetTeamMember", "team", "guest").Return(&model.TeamMember{Roles: "team_guest"}, nil)
+	api.On("GetChannelMember", "channel", "guest").Return(&model.ChannelMember{Roles: "channel_guest"}, nil)
+	api.On("GetUser", "lead").Return(&model.User{Id: "lead", Roles: "system_user"}, nil)
+	api.On("GetTeamMember", "team", "lead").Return(&model.TeamMember{Roles: "team_user team_admin"}, nil)
+	api.On("GetChannelMember", "channel", "lead").Return(&model.ChannelMember{Roles: "channel_user"}, nil)
+
+	p := &Plugin{kvstore: &rulesStore{}}
+	p.SetAPI(api)
+	channel := &model.Channel{Id: "channel", TeamId: "team", Type: model.ChannelTypeOpen}
+
+	cfg := &Configuration{RoleBlockNames: []string{"system_guest"}}
+	assert.False(t, p.isAuthorized(cfg, channel, "guest"))
+	assert.True(t, p.isAuthorized(cfg, channel, "lead"))
+
+	cfg = &Configuration{RoleAllowNames: []string{"team_admin"}}
+	assert.False(t, p.isAuthorized(cfg, channel, "guest"))
+	assert.True(t, p.isAuthorized(cfg, channel, "lead"))
+}
+
+func TestIsAuthorizedWithAccessRules(t *testing.T) {
+	store := &rulesStore{}
+	store.rules.Channels.Set("onboarded", true)
+	store.rules.Users.Set("bob", false)
+	p := &Plugin{kvstore: store}
+
+	cfg := &Configuration{
+		ChannelAccess:   "allow_selected",
+		ChannelAllowIDs: []string{"console"},
+		UserAccess:      "block_selected",
+	}
+	onboarded := &model.Channel{Id: "onboarded", Type: model.ChannelTypeOpen}
+	console := &model.Channel{Id: "console", Type: model.ChannelTypeOpen}
+	other := &model.Channel{Id: "other", Type: model.ChannelTypeOpen}
+
+	// command rules are merged with the console lists
+	assert.True(t, p.isAuthorized(cfg, onboarded, "alice"))
+	assert.True(t, p.isAuthorized(cfg, console, "alice"))
+	assert.False(t, p.isAuthorized(cfg, other, "alice"))
+	assert.False(t, p.isAuthorized(cfg, onboarded, "bob"))
+
+	// the configuration itself is left untouched
+	assert.Equal(t, []string{"console"}, cfg.ChannelAllowIDs)
+}
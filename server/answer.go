@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// answerStreamTimeout is the time an answer has to stream once the backend
+// started answering.
+const answerStreamTimeout = 60 * time.Second
+
+// questionTimeout bounds a single question, from the request to the last
+// token: every attempt may use the connect and read timeouts, every retry may
+// wait the longest retry delay, and the answer then has answerStreamTimeout.
+func (c *Configuration) questionTimeout() time.Duration {
+	policy := newRetryPolicy(c)
+	attempt := secondsOr(c.ConnectTimeoutSeconds, defaultConnectTimeout) + secondsOr(c.ReadTimeoutSeconds, defaultReadTimeout)
+	return time.Duration(policy.MaxAttempts)*attempt + time.Duration(policy.MaxAttempts-1)*policy.MaxDelay + answerStreamTimeout
+}
+
+// replyTarget describes who asked and where the bot answers.
+type replyTarget struct {
+	UserID    string
+	ChannelID string
+	RootID    string
+	Locale    string
+
+	// ReplyPostIDs are the posts of an earlier answer to overwrite when the
+	// question was edited; empty for a new answer.
+	ReplyPostIDs []string
+}
+
+// question is a request waiting in the worker queue for an answer.
+type question struct {
+	req      *AskRequest
+	target   replyTarget
+	convKey  string   // key of the backend conversation, empty for none
+	postID   string   // the post that asked, empty for /mu
+	fileIDs  []string // files attached to the post
+	createAt int64    // when the question was asked, in milliseconds
+	claimed  bool     // the post was claimed cluster-wide and needs an outcome
+}
+
+// enqueueQuestion queues q on the worker of its channel. When a rate limit is
+// exceeded or the queue is full the user is told to try again later instead.
+func (p *Plugin) enqueueQuestion(q *question) {
+	counted, hit := p.checkRateLimits(q)
+	if hit != nil {
+		logDebug(p, "rate limit exceeded", "scope", hit.scope, "id", hit.id, "limit", hit.limit)
+		p.recordOutcome(q, errRateLimited)
+		p.sendEphemeral(q.target, hit.message(q.target.Locale))
+		return
+	}
+	if p.submitJob(q.target.ChannelID, func() { p.answerQuestion(q) }) {
+		return
+	}
+	logDebug(p, "question queue full", "channel", q.target.ChannelID)
+	p.uncountRequest(counted)
+	p.recordOutcome(q, errQueueFull)
+	p.sendEphemeral(q.target, localize(q.target.Locale, msgBusy))
+}
+
+// answerQuestion answers q within the question timeout and keeps the backend
+// conversation of its thread.
+func (p *Plugin) answerQuestion(q *question) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.getConfiguration().questionTimeout())
+	defer cancel()
+
+	// feedback for the user while the backend works
+	p.markWorking(q.postID)
+	stopTyping := p.startTyping(q.target)
+
+	q.req.ConversationID = p.loadConversationID(q.convKey)
+	q.req.History = p.threadHistory(q)
+	q.req.Files = p.fileContext(q.fileIDs, q.target)
+	answer, replyPostIDs, err := p.streamAnswer(ctx, q.req, q.target)
+	stopTyping()
+	p.markDone(q.postID, err)
+	p.recordOutcome(q, err)
+	if err != nil {
+		return
+	}
+	p.storeConversationID(q.convKey, q.req.ConversationID, answer.ConversationID)
+
+	if q.postID != "" {
+		if err := p.kvstore.SetReply(q.postID, &kvstore.Reply{ReplyPostIDs: replyPostIDs}); err != nil {
+			logError(p, err, "cannot store reply", "post_id", q.postID)
+		}
+		// the question may have been deleted before the reply was linked to it
+		if p.questionDeleted(q.postID) {
+			p.retractReplies(q.postID, q.target.Locale)
+		}
+	}
+}
+
+// newReplyWriter returns the writer for the answer at target. When
+// regenerating, it overwrites the first post of the earlier answer, whose
+// other posts finishReply removes; otherwise, or if that post is gone, it
+// creates a new placeholder.
+func (p *Plugin) newReplyWriter(target replyTarget) (*postWriter, error) {
+	placeholder := localize(target.Locale, msgTyping)
+	if len(target.ReplyPostIDs) > 0 {
+		post, appErr := p.API.GetPost(target.ReplyPostIDs[0])
+		if appErr == nil {
+			return resumePostWriter(p.API, post, placeholder)
+		}
+		logError(p, appErr, "cannot get previous reply", "post_id", target.ReplyPostIDs[0])
+	}
+
+	return newPostWriter(p.API, &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: target.ChannelID,
+		RootId:    target.RootID,
+		Message:   placeholder,
+	})
+}
+
+// streamAnswer sends req to the backend and streams the answer into a bot
+// reply at target. It returns the final answer metadata and the IDs of the
+// reply posts, or the error after the user has been told about it.
+func (p *Plugin) streamAnswer(ctx context.Context, req *AskRequest, target replyTarget) (*Answer, []string, error) {
+	writer, err := p.newReplyWriter(target)
+	if err != nil {
+		logError(p, err, "cannot create reply placeholder")
+		return nil, nil, err
+	}
+
+	backend, err := p.getBackend()
+	if err != nil {
+		p.failAnswer(writer, target, err)
+		return nil, nil, err
+	}
+
+	stream, err := backend.Stream(ctx, req)
+	if err != nil {
+		p.failAnswer(writer, target, err)
+		return nil, nil, err
+	}
+	defer stream.Close()
+
+	if _, err := io.Copy(writer, stream); err != nil {
+		p.failAnswer(writer, target, err)
+		return nil, nil, err
+	}
+
+	answer := stream.Answer()
+	reply := strings.TrimSpace(writer.Text())
+	if reply == "" {
+		reply = localize(target.Locale, msgEmptyAnswer)
+	}
+	if len(target.ReplyPostIDs) > 0 {
+		reply += "\n\n" + localize(target.Locale, msgRegenerated)
+	}
+	style := p.getConfiguration().citationStyle(target.ChannelID)
+	// the footnotes go below the last part, so leave room for them
+	footnotes := utf8.RuneCountInString(footnoteText(answer, style, target.Locale))
+	replyPostIDs := p.finishReply(writer, target, reply, footnotes, func(post *model.Post) {
+		addCitations(post, answer, style, target.Locale)
+	})
+	return answer, replyPostIDs, nil
+}
+
+// failAnswer ends a reply whose backend call failed. While the circuit breaker
+// is open the placeholder shows the unavailable message. Otherwise a partial
+// answer is kept with a warning, an empty placeholder is removed, and the user
+// gets an ephemeral explanation. A failed regeneration always restores the
+// earlier answer and explains the failure in an ephemeral post, even while
+// the circuit breaker is open.
+func (p *Plugin) failAnswer(writer *postWriter, target replyTarget, err error) {
+	kind := errorKind(err)
+	logError(p, err, "backend request failed", "kind", kind)
+	regenerating := len(target.ReplyPostIDs) > 0
+
+	if kind == ErrKindUnavailable && !regenerating {
+		if appErr := writer.Finish(p.failureMessage(target.Locale, err), nil); appErr != nil {
+			logError(p, appErr, "cannot update reply")
+		}
+		return
+	}
+
+	if partial := strings.TrimSpace(writer.Text()); partial != "" && !regenerating {
+		partial = splitMarkdown(partial, replyMaxRunes)[0]
+		if appErr := writer.Finish(partial+"\n\n:warning: "+localize(target.Locale, msgIncomplete), nil); appErr != nil {
+			logError(p, appErr, "cannot update reply")
+		}
+	} else if appErr := writer.Discard(); appErr != nil {
+		logError(p, appErr, "cannot discard reply placeholder")
+	}
+
+	if kind != ErrKindCanceled {
+		p.notifyFailure(target, err)
+	}
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mattermost/mattermost/server/public/model"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
 	"github.com/mattermost/mattermost/server/public/plugin"
 )
 
@@ -19,6 +24,11 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 
 	apiRouter.HandleFunc("/hello", p.HelloWorld).Methods(http.MethodGet)
 
+	adminRouter := apiRouter.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(p.SystemAdminRequired)
+	adminRouter.HandleFunc("/status", p.handleStatus).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/usage", p.handleUsage).Methods(http.MethodGet)
+
 	router.ServeHTTP(w, r)
 }
 
@@ -34,6 +44,81 @@ func (p *Plugin) MattermostAuthorizationRequired(next http.Handler) http.Handler
 	})
 }
 
+// SystemAdminRequired rejects requests from users without the manage_system permission.
+func (p *Plugin) SystemAdminRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("Mattermost-User-ID")
+		if !p.API.HasPermissionTo(userID, model.PermissionManageSystem) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes v as a JSON response body.
+func (p *Plugin) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		p.API.LogError("Failed to write response", "error", err)
+	}
+}
+
+// handleStatus reports the selected backend and the circuit breaker state.
+func (p *Plugin) handleStatus(w http.ResponseWriter, r *http.Request) {
+	backend := p.getConfiguration().Backend
+	if backend == "" {
+		backend = backendMuChat
+	}
+	p.writeJSON(w, map[string]any{
+		"backend": backend,
+		"breaker": p.breaker.status(),
+	})
+}
+
+// maxUsageDays bounds the date range of a single usage query.
+const maxUsageDays = 92
+
+// handleUsage returns the usage roll-ups for the inclusive UTC date range given
+// by the "from" and "to" query parameters (YYYY-MM-DD, both default to today).
+func (p *Plugin) handleUsage(w http.ResponseWriter, r *http.Request) {
+	today := time.Now().UTC().Format(usageDayFormat)
+	parse := func(name string) (time.Time, error) {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			value = today
+		}
+		return time.Parse(usageDayFormat, value)
+	}
+	from, err := parse("from")
+	if err != nil {
+		http.Error(w, "invalid from date", http.StatusBadRequest)
+		return
+	}
+	to, err := parse("to")
+	if err != nil {
+		http.Error(w, "invalid to date", http.StatusBadRequest)
+		return
+	}
+	if to.Before(from) || to.Sub(from) > maxUsageDays*24*time.Hour {
+		http.Error(w, "invalid date range", http.StatusBadRequest)
+		return
+	}
+
+	days := []*kvstore.DailyUsage{}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		usage, err := p.kvstore.GetDailyUsage(day.Format(usageDayFormat))
+		if err != nil {
+			p.API.LogError("Failed to read usage", "error", err.Error())
+			http.Error(w, "failed to read usage", http.StatusInternalServerError)
+			return
+		}
+		days = append(days, usage)
+	}
+	p.writeJSON(w, map[string]any{"days": days})
+}
+
 func (p *Plugin) HelloWorld(w http.ResponseWriter, r *http.Request) {
 	if _, err := w.Write([]byte("Hello, world!")); err != nil {
 		p.API.LogError("Failed to write response", "error", err)
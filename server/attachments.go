@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	defaultFileContextMaxKB      = 64
+	defaultFileContextMaxTotalKB = 256
+)
+
+// FileContext is the text of a file attached to a question.
+type FileContext struct {
+	Name      string
+	Text      string
+	Truncated bool // only the beginning of the file is included
+}
+
+// textFileExtensions are the extensions of files read as plain text.
+var textFileExtensions = map[string]bool{
+	"txt": true, "text": true, "log": true, "md": true, "markdown": true, "rst": true,
+	"csv": true, "tsv": true, "json": true, "jsonl": true, "ndjson": true,
+	"xml": true, "yaml": true, "yml": true, "toml": true, "ini": true, "cfg": true, "conf": true, "env": true,
+	"html": true, "htm": true, "css": true, "scss": true, "sql": true, "graphql": true, "proto": true,
+	"go": true, "mod": true, "py": true, "rb": true, "php": true, "pl": true, "lua": true, "r": true,
+	"js": true, "jsx": true, "ts": true, "tsx": true, "mjs": true, "vue": true, "svelte": true,
+	"java": true, "kt": true, "kts": true, "scala": true, "groovy": true, "gradle": true,
+	"c": true, "h": true, "cc": true, "cpp": true, "hpp": true, "cs": true, "rs": true, "swift": true, "m": true,
+	"sh": true, "bash": true, "zsh": true, "ps1": true, "bat": true, "dockerfile": true, "makefile": true,
+	"tf": true, "hcl": true, "diff": true, "patch": true,
+}
+
+// textMimeTypes are the non-"text/" MIME types read as plain text.
+var textMimeTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/javascript": true,
+	"application/x-sh":       true,
+	"application/sql":        true,
+	"application/toml":       true,
+}
+
+// isTextFile reports whether a file is read as context, by extension or MIME
+// type.
+func isTextFile(info *model.FileInfo) bool {
+	ext := strings.ToLower(strings.TrimPrefix(info.Extension, "."))
+	if ext == "" {
+		// files such as "Dockerfile" or "Makefile"
+		ext = strings.ToLower(info.Name)
+	}
+	if textFileExtensions[ext] {
+		return true
+	}
+	mime, _, _ := strings.Cut(strings.ToLower(info.MimeType), ";")
+	return strings.HasPrefix(mime, "text/") || textMimeTypes[strings.TrimSpace(mime)]
+}
+
+// fileContext reads the text files attached to a question within the
+// configured size limits: larger files are skipped, and the file that reaches
+// the total limit is cut short. The asking user is told about both.
+func (p *Plugin) fileContext(fileIDs []string, target replyTarget) []FileContext {
+	cfg := p.getConfiguration()
+	if !cfg.EnableFileContext || len(fileIDs) == 0 {
+		return nil
+	}
+	perFile := kilobytesOr(cfg.FileContextMaxKB, defaultFileContextMaxKB)
+	remaining := kilobytesOr(cfg.FileContextMaxTotalKB, defaultFileContextMaxTotalKB)
+
+	var files []FileContext
+	var unsupported, tooLarge, truncated []string
+	for _, id := range fileIDs {
+		info, appErr := p.API.GetFileInfo(id)
+		if appErr != nil {
+			logError(p, appErr, "cannot get file info", "file_id", id)
+			continue
+		}
+		if !isTextFile(info) {
+			unsupported = append(unsupported, info.Name)
+			continue
+		}
+		// the file API only reads whole files, so files over the per-file
+		// limit are skipped rather than read to keep their beginning
+		if info.Size > int64(perFile) || remaining <= 0 {
+			tooLarge = append(tooLarge, info.Name)
+			continue
+		}
+
+		data, appErr := p.API.GetFile(id)
+		if appErr != nil {
+			logError(p, appErr, "cannot read file", "file_id", id)
+			continue
+		}
+		if bytes.IndexByte(data, 0) >= 0 {
+			// binary content behind a text extension
+			unsupported = append(unsupported, info.Name)
+			continue
+		}
+
+		file := FileContext{Name: info.Name}
+		file.Text, file.Truncated = truncateUTF8(data, min(perFile, remaining))
+		if !utf8.ValidString(file.Text) {
+			unsupported = append(unsupported, info.Name)
+			continue
+		}
+		if file.Truncated {
+			truncated = append(truncated, info.Name)
+		}
+		remaining -= len(file.Text)
+		files = append(files, file)
+	}
+
+	var notices []string
+	for _, skipped := range []struct {
+		id    string
+		names []string
+	}{
+		{msgFileUnsupported, unsupported},
+		{msgFileTooLarge, tooLarge},
+		{msgFileTruncated, truncated},
+	} {
+		if len(skipped.names) > 0 {
+			notices = append(notices, fmt.Sprintf(localize(target.Locale, skipped.id), "`"+strings.Join(skipped.names, "`, `")+"`"))
+		}
+	}
+	if len(notices) > 0 {
+		p.sendEphemeral(target, strings.Join(notices, "\n"))
+	}
+	return files
+}
+
+// kilobytesOr converts a size setting in kilobytes to bytes, using def when
+// the setting is not positive.
+func kilobytesOr(kb, def int) int {
+	if kb <= 0 {
+		kb = def
+	}
+	return kb * 1024
+}
+
+// truncateUTF8 returns at most limit bytes of data as a string, cut at a rune
+// boundary, and whether anything was cut.
+func truncateUTF8(data []byte, limit int) (string, bool) {
+	if len(data) <= limit {
+		return string(data), false
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(data[cut]) {
+		cut--
+	}
+	return string(data[:cut]), true
+}
+
+// questionWithFiles returns the question followed by the attached files, each
+// labelled with its name and fenced as a code block.
+func questionWithFiles(req *AskRequest) string {
+	if len(req.Files) == 0 {
+		return req.Query
+	}
+	var sb strings.Builder
+	sb.WriteString(req.Query)
+	sb.WriteString("\n\nAttached files:")
+	for _, file := range req.Files {
+		sb.WriteString("\n\nFile \"" + file.Name + "\"")
+		if file.Truncated {
+			sb.WriteString(" (truncated, only the beginning is included)")
+		}
+		fence := codeFenceFor(file.Text)
+		sb.WriteString(":\n" + fence + "\n" + file.Text)
+		if !strings.HasSuffix(file.Text, "\n") {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(fence)
+	}
+	return sb.String()
+}
+
+// codeFenceFor returns a backtick fence longer than any backtick run in text,
+// so that the text cannot close it.
+func codeFenceFor(text string) string {
+	longest, run := 0, 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestIsTextFile(t *testing.T) {
+	assert.True(t, isTextFile(&model.FileInfo{Name: "app.log", Extension: "log"}))
+	assert.True(t, isTextFile(&model.FileInfo{Name: "main.GO", Extension: "GO"}))
+	assert.True(t, isTextFile(&model.FileInfo{Name: "Dockerfile"}))
+	assert.True(t, isTextFile(&model.FileInfo{Name: "data", MimeType: "application/json; charset=utf-8"}))
+	assert.True(t, isTextFile(&model.FileInfo{Name: "notes", MimeType: "text/plain"}))
+	assert.False(t, isTextFile(&model.FileInfo{Name: "photo.png", Extension: "png", MimeType: "image/png"}))
+	assert.False(t, isTextFile(&model.FileInfo{Name: "report.pdf", Extension: "pdf", MimeType: "application/pdf"}))
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	text, truncated := truncateUTF8([]byte("hello"), 10)
+	assert.Equal(t, "hello", text)
+	assert.False(t, truncated)
+
+	// "سلام" is two bytes per rune; a cut inside a rune moves back to its start
+	text, truncated = truncateUTF8([]byte("سلام"), 5)
+	assert.Equal(t, "سل", text)
+	assert.True(t, truncated)
+}
+
+func TestQuestionWithFiles(t *testing.T) {
+	assert.Equal(t, "why?", questionWithFiles(&AskRequest{Query: "why?"}))
+
+	query := questionWithFiles(&AskRequest{
+		Query: "what went wrong here?",
+		Files: []FileContext{
+			{Name: "app.log", Text: "ERROR boom\n"},
+			{Name: "notes.md", Text: "use ```code```", Truncated: true},
+		},
+	})
+	assert.Equal(t, "what went wrong here?\n\nAttached files:"+
+		"\n\nFile \"app.log\":\n```\nERROR boom\n```"+
+		"\n\nFile \"notes.md\" (truncated, only the beginning is included):\n````\nuse ```code```\n````", query)
+}
+
+func TestFileContext(t *testing.T) {
+	api := &plugintest.API{}
+	files := map[string]*model.FileInfo{
+		"small": {Name: "small.log", Extension: "log", Size: 1500},
+		"big":   {Name: "big.log", Extension: "log", Size: 3000},
+		"last":  {Name: "last.log", Extension: "log", Size: 1000},
+	}
+	for id, info := range files {
+		api.On("GetFileInfo", id).Return(info, nil)
+	}
+	api.On("GetFile", "small").Return([]byte(strings.Repeat("a", 1500)), nil)
+	api.On("GetFile", "last").Return([]byte(strings.Repeat("b", 1000)), nil)
+	var notices []string
+	api.On("SendEphemeralPost", "user", mock.AnythingOfType("*model.Post")).Return(func(_ string, post *model.Post) *model.Post {
+		notices = append(notices, post.Message)
+		return post
+	})
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.setConfiguration(&Configuration{EnableFileContext: true, FileContextMaxKB: 2, FileContextMaxTotalKB: 2})
+
+	got := p.fileContext([]string{"small", "big", "last"}, replyTarget{UserID: "user", Locale: "en"})
+
+	// a file over the per-file limit is never read
+	api.AssertNotCalled(t, "GetFile", "big")
+	assert.Equal(t, []FileContext{
+		{Name: "small.log", Text: strings.Repeat("a", 1500)},
+		{Name: "last.log", Text: strings.Repeat("b", 548), Truncated: true},
+	}, got)
+	assert.Equal(t, []string{
+		"These files exceed the size limit and were not sent to the assistant: `big.log`\n" +
+			"Only the beginning of these files was sent to the assistant: `last.log`",
+	}, notices)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Backend names accepted by the "Backend" plugin setting.
+const (
+	backendMuChat = "muchat"
+	backendOpenAI = "openai"
+	backendEcho   = "echo"
+)
+
+// AskRequest is a single question sent to an AI backend.
+type AskRequest struct {
+	Query string
+	// ConversationID continues an earlier conversation when the backend supports it.
+	ConversationID string
+	// History holds the earlier messages of the thread, oldest first.
+	History []ChatMessage
+	// Files holds the text of files attached to the question.
+	Files []FileContext
+
+	// Who asked, for usage accounting; not sent to the backend.
+	UserID    string
+	ChannelID string
+	TeamID    string
+}
+
+// Answer is the complete reply returned by an AI backend.
+type Answer struct {
+	Text           string
+	ConversationID string
+	MessageID      string
+	Sources        []Source
+	Usage          Usage
+}
+
+// Usage is the consumption reported by the backend for one answer. Backends
+// that do not report it leave it zero.
+type Usage struct {
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Credits          float64 `json:"credits"`
+}
+
+// Source is a document the backend based its answer on.
+type Source struct {
+	Title   string
+	URL     string
+	Snippet string
+}
+
+// AnswerStream is a streamed answer. Reading it yields the answer text; Answer
+// returns the metadata reported by the backend and is complete once the stream
+// has been read to EOF.
+type AnswerStream interface {
+	io.ReadCloser
+	Answer() *Answer
+}
+
+// pipeStream is an AnswerStream fed by a producer goroutine.
+type pipeStream struct {
+	*io.PipeReader
+	pw *io.PipeWriter
+
+	mu     sync.Mutex
+	answer Answer
+}
+
+func newPipeStream() *pipeStream {
+	pr, pw := io.Pipe()
+	return &pipeStream{PipeReader: pr, pw: pw}
+}
+
+// Answer returns a copy of the metadata collected so far.
+func (s *pipeStream) Answer() *Answer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	answer := s.answer
+	return &answer
+}
+
+// update lets the producer change the metadata.
+func (s *pipeStream) update(fn func(*Answer)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.answer)
+}
+
+// staticStream is an AnswerStream over an already complete answer.
+type staticStream struct {
+	io.Reader
+	answer *Answer
+}
+
+func newStaticStream(answer *Answer) *staticStream {
+	return &staticStream{Reader: strings.NewReader(answer.Text), answer: answer}
+}
+
+func (s *staticStream) Close() error    { return nil }
+func (s *staticStream) Answer() *Answer { return s.answer }
+
+// Backend abstracts the AI provider the bot forwards questions to.
+type Backend interface {
+	// Ask sends the request and waits for the complete answer.
+	Ask(ctx context.Context, req *AskRequest) (*Answer, error)
+	// Stream sends the request and returns the answer text as it is generated.
+	Stream(ctx context.Context, req *AskRequest) (AnswerStream, error)
+	// Health reports whether the backend is reachable and the credentials are accepted.
+	Health(ctx context.Context) error
+}
+
+// newBackend builds the backend selected in the plugin settings.
+func newBackend(cfg *Configuration, httpClient *http.Client) (Backend, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "", backendMuChat:
+		return NewMuChatClient(cfg.MuChatBaseURL, cfg.MuChatApiKey, cfg.AgentID, httpClient), nil
+	case backendOpenAI:
+		return NewOpenAIClient(cfg.OpenAIBaseURL, cfg.OpenAIApiKey, cfg.OpenAIModel, httpClient), nil
+	case backendEcho:
+		return NewEchoBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+}
+
+// backendSettings are the configuration values the shared backend is built
+// from; the backend is only rebuilt when one of them changes.
+type backendSettings struct {
+	Backend                                  string
+	MuChatBaseURL, MuChatApiKey, AgentID     string
+	OpenAIBaseURL, OpenAIApiKey, OpenAIModel string
+	ProxyURL                                 string
+	CACertificate                            string
+	ClientCertificate, ClientKey             string
+	ConnectTimeoutSeconds                    int
+	ReadTimeoutSeconds                       int
+	RetryMaxAttempts                         int
+	RetryBaseDelayMs                         int
+	RetryMaxDelaySeconds                     int
+}
+
+func newBackendSettings(cfg *Configuration) backendSettings {
+	return backendSettings{
+		Backend:               cfg.Backend,
+		MuChatBaseURL:         cfg.MuChatBaseURL,
+		MuChatApiKey:          cfg.MuChatApiKey,
+		AgentID:               cfg.AgentID,
+		OpenAIBaseURL:         cfg.OpenAIBaseURL,
+		OpenAIApiKey:          cfg.OpenAIApiKey,
+		OpenAIModel:           cfg.OpenAIModel,
+		ProxyURL:              cfg.ProxyURL,
+		CACertificate:         cfg.CACertificate,
+		ClientCertificate:     cfg.ClientCertificate,
+		ClientKey:             cfg.ClientKey,
+		ConnectTimeoutSeconds: cfg.ConnectTimeoutSeconds,
+		ReadTimeoutSeconds:    cfg.ReadTimeoutSeconds,
+		RetryMaxAttempts:      cfg.RetryMaxAttempts,
+		RetryBaseDelayMs:      cfg.RetryBaseDelayMs,
+		RetryMaxDelaySeconds:  cfg.RetryMaxDelaySeconds,
+	}
+}
+
+// sharedBackend is the long-lived backend owned by the plugin together with
+// the HTTP client it uses.
+type sharedBackend struct {
+	settings   backendSettings
+	backend    Backend
+	httpClient *http.Client
+	err        error // why the settings could not be applied, if they could not
+}
+
+// misconfiguredBackend fails every request with the error that kept the
+// shared backend from being built.
+type misconfiguredBackend struct {
+	err error
+}
+
+func (b *misconfiguredBackend) fail() error {
+	return &BackendError{Kind: ErrKindUnavailable, Err: b.err}
+}
+
+func (b *misconfiguredBackend) Ask(context.Context, *AskRequest) (*Answer, error) {
+	return nil, b.fail()
+}
+
+func (b *misconfiguredBackend) Stream(context.Context, *AskRequest) (AnswerStream, error) {
+	return nil, b.fail()
+}
+
+func (b *misconfiguredBackend) Health(context.Context) error {
+	return b.fail()
+}
+
+// applyBackendConfiguration rebuilds the shared backend when the settings it
+// depends on changed and swaps it in atomically. Requests already running
+// finish on the previous backend, whose idle connections are then released.
+//
+// Invalid settings are returned as an error. A working previous backend is
+// kept; otherwise a backend failing with the error is installed, so that
+// questions get an answer instead of waiting for a backend that never comes.
+func (p *Plugin) applyBackendConfiguration(cfg *Configuration) error {
+	p.limiter.setLimit(cfg.MaxConcurrentRequests)
+	p.breaker.configure(cfg.BreakerFailureThreshold, secondsOr(cfg.BreakerCooldownSeconds, defaultBreakerCooldown))
+
+	settings := newBackendSettings(cfg)
+	p.backendLock.RLock()
+	current := p.backend
+	p.backendLock.RUnlock()
+	if current != nil && current.settings == settings {
+		return current.err
+	}
+
+	next, err := p.newSharedBackend(cfg, settings)
+	if err != nil {
+		if current != nil && current.err == nil {
+			return err
+		}
+		next = &sharedBackend{settings: settings, backend: &misconfiguredBackend{err: err}, err: err}
+	}
+
+	p.backendLock.Lock()
+	p.backend = next
+	p.backendLock.Unlock()
+
+	if current != nil && current.httpClient != nil {
+		current.httpClient.CloseIdleConnections()
+	}
+	return err
+}
+
+// newSharedBackend builds the backend selected in cfg with its HTTP client.
+func (p *Plugin) newSharedBackend(cfg *Configuration, settings backendSettings) (*sharedBackend, error) {
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid connection settings")
+	}
+	raw, err := newBackend(cfg, httpClient)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedBackend{
+		settings: settings,
+		// the breaker rejects calls before they take a concurrency slot
+		backend: &meteredBackend{
+			Backend: &breakerBackend{
+				Backend: &limitedBackend{Backend: raw, limiter: &p.limiter},
+				breaker: &p.breaker,
+			},
+			agent:  usageAgent(cfg),
+			record: p.recordUsage,
+		},
+		httpClient: httpClient,
+	}, nil
+}
+
+// getBackend returns the shared backend.
+func (p *Plugin) getBackend() (Backend, error) {
+	p.backendLock.RLock()
+	defer p.backendLock.RUnlock()
+	if p.backend == nil {
+		return nil, errors.New("backend is not configured")
+	}
+	return p.backend.backend, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// errCircuitOpen is returned without contacting the backend while the circuit
+// breaker is open.
+var errCircuitOpen = errors.New("backend circuit breaker is open")
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// circuitBreaker stops calls to the backend after consecutive failures. Once
+// the cooldown has elapsed a single probe request is let through (half-open);
+// its outcome closes or re-opens the circuit. The zero value is usable and
+// uses the default threshold and cooldown.
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	threshold int
+	cooldown  time.Duration
+
+	state     breakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+
+	now func() time.Time
+}
+
+// breakerStatus is the state of the breaker as reported to admins.
+type breakerStatus struct {
+	State               breakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Threshold           int          `json:"threshold"`
+	CooldownSeconds     int          `json:"cooldown_seconds"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+	LastError           string       `json:"last_error,omitempty"`
+}
+
+// configure applies the breaker settings without resetting its state.
+func (b *circuitBreaker) configure(threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold = threshold
+	b.cooldown = cooldown
+}
+
+func (b *circuitBreaker) clock() time.Time {
+	if b.now != nil {
+		return b.now()
+	}
+	return time.Now()
+}
+
+func (b *circuitBreaker) limits() (int, time.Duration) {
+	threshold, cooldown := b.threshold, b.cooldown
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return threshold, cooldown
+}
+
+// allow returns errCircuitOpen if the call must be short-circuited.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, cooldown := b.limits()
+	switch b.state {
+	case breakerOpen:
+		if b.clock().Sub(b.openedAt) < cooldown {
+			return errCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return nil
+	case breakerHalfOpen:
+		if b.probing {
+			return errCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record updates the breaker with the outcome of an allowed call.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if errors.Is(err, context.Canceled) || notSent(err) {
+		// the caller gave up or the request never reached the backend; this
+		// says nothing about the backend
+		b.probing = false
+		return
+	}
+
+	threshold, _ := b.limits()
+	if err == nil {
+		b.state = breakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	b.lastError = err.Error()
+	if b.state == breakerHalfOpen || b.failures >= threshold {
+		b.state = breakerOpen
+		b.openedAt = b.clock()
+	}
+	b.probing = false
+}
+
+// status returns a snapshot of the breaker.
+func (b *circuitBreaker) status() breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	threshold, cooldown := b.limits()
+	s := breakerStatus{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Threshold:           threshold,
+		CooldownSeconds:     int(cooldown / time.Second),
+		LastError:           b.lastError,
+	}
+	if s.State == "" {
+		s.State = breakerClosed
+	}
+	if s.State != breakerClosed {
+		openedAt := b.openedAt
+		s.OpenedAt = &openedAt
+	}
+	return s
+}
+
+// breakerBackend guards a Backend with a circuit breaker.
+type breakerBackend struct {
+	Backend
+	breaker *circuitBreaker
+}
+
+func (b *breakerBackend) Ask(ctx context.Context, req *AskRequest) (*Answer, error) {
+	if err := b.breaker.allow(); err != nil {
+		return nil, &BackendError{Kind: ErrKindUnavailable, Err: err}
+	}
+	answer, err := b.Backend.Ask(ctx, req)
+	b.breaker.record(err)
+	return answer, err
+}
+
+// Stream records the outcome when the stream ends, so that error events and
+// streams cut off in the middle of an answer count as failures.
+func (b *breakerBackend) Stream(ctx context.Context, req *AskRequest) (AnswerStream, error) {
+	if err := b.breaker.allow(); err != nil {
+		return nil, &BackendError{Kind: ErrKindUnavailable, Err: err}
+	}
+	stream, err := b.Backend.Stream(ctx, req)
+	if err != nil {
+		b.breaker.record(err)
+		return nil, err
+	}
+	return &breakerStream{AnswerStream: stream, breaker: b.breaker}, nil
+}
+
+// breakerStream records the outcome of a stream with its circuit breaker: a
+// success at the end of the stream, a failure at the first read error.
+type breakerStream struct {
+	AnswerStream
+	breaker *circuitBreaker
+	once    sync.Once
+}
+
+func (s *breakerStream) Read(p []byte) (int, error) {
+	n, err := s.AnswerStream.Read(p)
+	switch {
+	case errors.Is(err, io.EOF):
+		s.done(nil)
+	case err != nil:
+		s.done(err)
+	}
+	return n, err
+}
+
+// Close before the end of the stream means the reader gave up, which says
+// nothing about the backend.
+func (s *breakerStream) Close() error {
+	s.done(context.Canceled)
+	return s.AnswerStream.Close()
+}
+
+func (s *breakerStream) done(err error) {
+	s.once.Do(func() { s.breaker.record(err) })
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := &circuitBreaker{now: func() time.Time { return now }}
+	b.configure(2, time.Minute)
+	failure := errors.New("boom")
+
+	assert.NoError(t, b.allow())
+	b.record(failure)
+	assert.Equal(t, breakerClosed, b.status().State)
+
+	assert.NoError(t, b.allow())
+	b.record(failure)
+	assert.Equal(t, breakerOpen, b.status().State)
+	assert.ErrorIs(t, b.allow(), errCircuitOpen)
+
+	// after the cooldown a single probe is let through
+	now = now.Add(time.Minute)
+	assert.NoError(t, b.allow())
+	assert.Equal(t, breakerHalfOpen, b.status().State)
+	assert.ErrorIs(t, b.allow(), errCircuitOpen)
+
+	// a failed probe re-opens the circuit
+	b.record(failure)
+	assert.Equal(t, breakerOpen, b.status().State)
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, b.allow())
+	b.record(nil)
+	status := b.status()
+	assert.Equal(t, breakerClosed, status.State)
+	assert.Zero(t, status.ConsecutiveFailures)
+}
+
+// streamBackend streams a fixed text and then ends with err, or cleanly if
+// err is nil.
+type streamBackend struct {
+	Backend
+	text string
+	err  error
+}
+
+func (b *streamBackend) Stream(context.Context, *AskRequest) (AnswerStream, error) {
+	stream := newPipeStream()
+	go func() {
+		_, _ = stream.pw.Write([]byte(b.text))
+		stream.pw.CloseWithError(b.err)
+	}()
+	return stream, nil
+}
+
+func TestBreakerBackendStream(t *testing.T) {
+	read := func(b Backend) error {
+		stream, err := b.Stream(context.Background(), &AskRequest{})
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+		_, err = io.ReadAll(stream)
+		return err
+	}
+
+	breaker := &circuitBreaker{}
+	breaker.configure(2, time.Minute)
+	failing := &breakerBackend{Backend: &streamBackend{text: "partial", err: streamError(errors.New("cut off"))}, breaker: breaker}
+	working := &breakerBackend{Backend: &streamBackend{text: "answer"}, breaker: breaker}
+
+	// failures in the middle of a stream count
+	assert.Error(t, read(failing))
+	assert.Equal(t, 1, breaker.status().ConsecutiveFailures)
+	assert.NoError(t, read(working))
+	assert.Zero(t, breaker.status().ConsecutiveFailures)
+	assert.Error(t, read(failing))
+	assert.Error(t, read(failing))
+	assert.Equal(t, breakerOpen, breaker.status().State)
+
+	// a stream closed before its end counts as neither
+	breaker = &circuitBreaker{}
+	abandoned := &breakerBackend{Backend: &streamBackend{text: "answer", err: errors.New("not read")}, breaker: breaker}
+	stream, err := abandoned.Stream(context.Background(), &AskRequest{})
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+	assert.Zero(t, breaker.status().ConsecutiveFailures)
+}
+
+func TestBreakerBeforeLimiter(t *testing.T) {
+	limiter := &requestLimiter{}
+	limiter.setLimit(1)
+	breaker := &circuitBreaker{}
+	breaker.configure(1, time.Minute)
+	breaker.record(errors.New("boom"))
+
+	b := &breakerBackend{Backend: &limitedBackend{Backend: NewEchoBackend(), limiter: limiter}, breaker: breaker}
+	_, err := b.Stream(context.Background(), &AskRequest{})
+	assert.Equal(t, ErrKindUnavailable, errorKind(err))
+
+	// the rejected call did not take the only slot
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	release, err := limiter.acquire(ctx)
+	require.NoError(t, err)
+	release()
+}
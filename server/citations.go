@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Values of the "CitationStyle" setting.
+const (
+	citationFootnotes  = "footnotes"
+	citationAttachment = "attachment"
+	citationHidden     = "hidden"
+)
+
+// maxCitations bounds the number of sources rendered under a reply.
+const maxCitations = 10
+
+// citationStyle returns how sources are rendered in a channel.
+func (c *Configuration) citationStyle(channelID string) string {
+	if contains(c.CitationHiddenChannelIDs, channelID) {
+		return citationHidden
+	}
+	switch c.CitationStyle {
+	case citationAttachment, citationHidden:
+		return c.CitationStyle
+	default:
+		return citationFootnotes
+	}
+}
+
+// uniqueSources drops sources without a title or URL and duplicates.
+func uniqueSources(sources []Source) []Source {
+	seen := make(map[string]bool)
+	var out []Source
+	for _, src := range sources {
+		key := src.URL
+		if key == "" {
+			key = src.Title
+		}
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, src)
+		if len(out) == maxCitations {
+			break
+		}
+	}
+	return out
+}
+
+// maxCitationRunes bounds the rendered list of sources, so that the room
+// reserved for it below a reply stays small.
+const maxCitationRunes = 2000
+
+// citationTitleRunes bounds the title of a single source.
+const citationTitleRunes = 200
+
+var (
+	// markdownEscaper escapes the characters that would format a title or
+	// end its link text.
+	markdownEscaper = strings.NewReplacer(
+		`\`, `\\`, "[", `\[`, "]", `\]`, "*", `\*`, "_", `\_`, "`", "\\`",
+		"~", `\~`, "<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+		"\r\n", " ", "\n", " ", "\r", " ",
+	)
+	// urlEscaper encodes the characters that would end a link destination.
+	urlEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+)
+
+// formatSource renders a source as a markdown list item body.
+func formatSource(src Source) string {
+	title := strings.TrimSpace(src.Title)
+	if utf8.RuneCountInString(title) > citationTitleRunes {
+		title = string([]rune(title)[:citationTitleRunes-1]) + "…"
+	}
+	title = markdownEscaper.Replace(title)
+	url := urlEscaper.Replace(strings.TrimSpace(src.URL))
+	switch {
+	case url != "" && title != "":
+		return fmt.Sprintf("[%s](%s)", title, url)
+	case url != "":
+		return fmt.Sprintf("<%s>", url)
+	default:
+		return title
+	}
+}
+
+// citationList renders sources as a numbered markdown list. Sources that do
+// not fit in maxCitationRunes are left out.
+func citationList(sources []Source) string {
+	var list strings.Builder
+	size, n := 0, 0
+	for _, src := range sources {
+		item := fmt.Sprintf("%d. %s", n+1, formatSource(src))
+		if n > 0 {
+			item = "\n" + item
+		}
+		itemRunes := utf8.RuneCountInString(item)
+		if size+itemRunes > maxCitationRunes {
+			continue
+		}
+		list.WriteString(item)
+		size += itemRunes
+		n++
+	}
+	return list.String()
+}
+
+// footnoteText returns the text addCitations appends to the message of
+// a reply, or "" if it appends none.
+func footnoteText(answer *Answer, style, locale string) string {
+	if style != citationFootnotes {
+		return ""
+	}
+	list := citationList(uniqueSources(answer.Sources))
+	if list == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n\n---\n**%s:**\n%s", localize(locale, msgSources), list)
+}
+
+// addCitations renders the sources of answer on the bot reply post according
+// to style: as numbered footnotes below the message or as a message attachment.
+func addCitations(post *model.Post, answer *Answer, style, locale string) {
+	switch style {
+	case citationFootnotes:
+		post.Message += footnoteText(answer, style, locale)
+	case citationAttachment:
+		list := citationList(uniqueSources(answer.Sources))
+		if list == "" {
+			return
+		}
+		model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+			Title: localize(locale, msgSources),
+			Text:  list,
+		}})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatSource(t *testing.T) {
+	for name, tc := range map[string]struct {
+		src  Source
+		want string
+	}{
+		"title and url":   {Source{Title: "Runbook", URL: "https://wiki/runbook"}, "[Runbook](https://wiki/runbook)"},
+		"url only":        {Source{URL: "https://wiki/runbook"}, "<https://wiki/runbook>"},
+		"title only":      {Source{Title: " Runbook "}, "Runbook"},
+		"markdown title":  {Source{Title: "[draft] *v2* | notes_1", URL: "https://wiki"}, `[\[draft\] \*v2\* \| notes\_1](https://wiki)`},
+		"multiline title": {Source{Title: "a\nb"}, "a b"},
+		"url with parens": {Source{Title: "Go", URL: "https://wiki/Go_(language) x"}, "[Go](https://wiki/Go_%28language%29%20x)"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, formatSource(tc.src))
+		})
+	}
+}
+
+func TestAddCitations(t *testing.T) {
+	answer := &Answer{Sources: []Source{
+		{Title: "Runbook", URL: "https://wiki/runbook"},
+		{Title: "Runbook again", URL: "https://wiki/runbook"},
+		{Title: "Postmortem"},
+	}}
+
+	t.Run("footnotes", func(t *testing.T) {
+		post := &model.Post{Message: "Restart it."}
+		addCitations(post, answer, citationFootnotes, "en")
+		assert.Equal(t, "Restart it.\n\n---\n**Sources:**\n1. [Runbook](https://wiki/runbook)\n2. Postmortem", post.Message)
+		assert.Equal(t, footnoteText(answer, citationFootnotes, "en"), strings.TrimPrefix(post.Message, "Restart it."))
+	})
+
+	t.Run("attachment", func(t *testing.T) {
+		post := &model.Post{Message: "Restart it."}
+		addCitations(post, answer, citationAttachment, "en")
+		assert.Equal(t, "Restart it.", post.Message)
+		require.Len(t, post.Attachments(), 1)
+		assert.Equal(t, "1. [Runbook](https://wiki/runbook)\n2. Postmortem", post.Attachments()[0].Text)
+		assert.Empty(t, footnoteText(answer, citationAttachment, "en"))
+	})
+
+	t.Run("hidden", func(t *testing.T) {
+		post := &model.Post{Message: "Restart it."}
+		addCitations(post, answer, citationHidden, "en")
+		assert.Equal(t, "Restart it.", post.Message)
+		assert.Empty(t, post.Attachments())
+	})
+
+	t.Run("long sources are bounded", func(t *testing.T) {
+		var sources []Source
+		for i := 0; i < maxCitations; i++ {
+			sources = append(sources, Source{
+				Title: strings.Repeat("t", 1000),
+				URL:   fmt.Sprintf("https://wiki/%d/%s", i, strings.Repeat("p", 300)),
+			})
+		}
+		list := citationList(sources)
+		assert.LessOrEqual(t, utf8.RuneCountInString(list), maxCitationRunes)
+		assert.True(t, strings.HasPrefix(list, "1. [tttt"))
+	})
+}
+
+func TestFinishReplyLeavesRoomForFootnotes(t *testing.T) {
+	api := &plugintest.API{}
+	var messages []string
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		messages = append(messages, post.Message)
+		created := post.Clone()
+		created.Id = model.NewId()
+		return created
+	}, nil)
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		messages = append(messages, post.Message)
+		return post.Clone()
+	}, nil)
+
+	p := &Plugin{botUserID: "bot"}
+	p.SetAPI(api)
+	p.setConfiguration(&Configuration{})
+
+	var sources []Source
+	for i := 0; i < maxCitations; i++ {
+		sources = append(sources, Source{
+			Title: strings.Repeat("title ", 30),
+			URL:   fmt.Sprintf("https://wiki/%d/%s", i, strings.Repeat("p", 100)),
+		})
+	}
+	answer := &Answer{Sources: sources}
+	target := replyTarget{ChannelID: "channel", Locale: "en"}
+
+	// an answer that just fits a post without its sources
+	reply := strings.Repeat("x", replyMaxRunes-1)
+
+	writer, appErr := newPostWriter(api, &model.Post{ChannelId: "channel", Message: "..."})
+	require.Nil(t, appErr)
+	messages = nil
+	footnotes := utf8.RuneCountInString(footnoteText(answer, citationFootnotes, "en"))
+	ids := p.finishReply(writer, target, reply, footnotes, func(post *model.Post) {
+		addCitations(post, answer, citationFootnotes, "en")
+	})
+
+	require.Len(t, ids, 2)
+	require.Len(t, messages, 2)
+	for _, message := range messages {
+		assert.LessOrEqual(t, utf8.RuneCountInString(message), model.PostMessageMaxRunesV2)
+	}
+	assert.Contains(t, messages[1], "**Sources:**")
+}
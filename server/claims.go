@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// postClaimStaleAfter is the age after which a claim without an outcome is
+// considered abandoned, for example because its node died. It covers the time
+// a question may wait in the queue plus the request itself.
+const postClaimStaleAfter = 10 * time.Minute
+
+// claimPost reports whether this node should answer a post. The claim is
+// shared by all nodes of a cluster and survives plugin restarts. A post with
+// a recorded outcome is never answered again, and a claim abandoned without
+// an outcome is taken over.
+func (p *Plugin) claimPost(postID string) bool {
+	claimed, err := p.kvstore.ClaimPost(postID)
+	if err != nil {
+		// without the KV store the nodes cannot agree; answering is better
+		// than staying silent
+		logError(p, err, "cannot claim post", "post_id", postID)
+		return true
+	}
+	if claimed {
+		return true
+	}
+
+	state, err := p.kvstore.GetPostState(postID)
+	if err != nil {
+		logError(p, err, "cannot get post state", "post_id", postID)
+		return false
+	}
+	if state == nil || state.Status != kvstore.PostProcessing {
+		logDebug(p, "post already handled", "post_id", postID)
+		return false
+	}
+	if age := time.Since(time.UnixMilli(state.UpdatedAt)); age < postClaimStaleAfter {
+		logDebug(p, "post being answered by another node", "post_id", postID)
+		return false
+	}
+
+	taken, err := p.kvstore.TakeOverPost(postID, state)
+	if err != nil {
+		logError(p, err, "cannot take over post", "post_id", postID)
+		return false
+	}
+	if taken {
+		p.API.LogInfo("Taking over an abandoned post", "post_id", postID)
+	}
+	return taken
+}
+
+// recordOutcome stores whether the answer to a claimed question was
+// delivered.
+func (p *Plugin) recordOutcome(q *question, err error) {
+	if !q.claimed {
+		return
+	}
+	status := kvstore.PostAnswered
+	if err != nil {
+		status = kvstore.PostFailed
+	}
+	if err := p.kvstore.SetPostOutcome(q.postID, status); err != nil {
+		logError(p, err, "cannot record post outcome", "post_id", q.postID, "status", status)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// postStore keeps post states in memory; other KVStore methods are not used.
+type postStore struct {
+	kvstore.KVStore
+	states map[string]kvstore.PostState
+}
+
+func (s *postStore) ClaimPost(postID string) (bool, error) {
+	if _, ok := s.states[postID]; ok {
+		return false, nil
+	}
+	s.states[postID] = kvstore.PostState{Status: kvstore.PostProcessing, UpdatedAt: model.GetMillis()}
+	return true, nil
+}
+
+func (s *postStore) TakeOverPost(postID string, stale *kvstore.PostState) (bool, error) {
+	if s.states[postID] != *stale {
+		return false, nil
+	}
+	s.states[postID] = kvstore.PostState{Status: kvstore.PostProcessing, UpdatedAt: model.GetMillis()}
+	return true, nil
+}
+
+func (s *postStore) SetPostOutcome(postID, status string) error {
+	s.states[postID] = kvstore.PostState{Status: status, UpdatedAt: model.GetMillis()}
+	return nil
+}
+
+func (s *postStore) GetPostState(postID string) (*kvstore.PostState, error) {
+	state, ok := s.states[postID]
+	if !ok {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func TestClaimPost(t *testing.T) {
+	store := &postStore{states: map[string]kvstore.PostState{}}
+	api := &plugintest.API{}
+	api.On("LogInfo", "Taking over an abandoned post", "post_id", mock.Anything).Return()
+	p := &Plugin{kvstore: store}
+	p.SetAPI(api)
+
+	// a post is answered once
+	assert.True(t, p.claimPost("new"))
+	assert.False(t, p.claimPost("new"), "another node is answering it")
+
+	// a recorded outcome is final, however old
+	p.recordOutcome(&question{postID: "new", claimed: true}, nil)
+	answered := store.states["new"]
+	answered.UpdatedAt = time.Now().Add(-time.Hour).UnixMilli()
+	store.states["new"] = answered
+	assert.False(t, p.claimPost("new"))
+
+	// a claim without an outcome is taken over once it is stale
+	store.states["abandoned"] = kvstore.PostState{
+		Status:    kvstore.PostProcessing,
+		UpdatedAt: time.Now().Add(-postClaimStaleAfter - time.Minute).UnixMilli(),
+	}
+	assert.True(t, p.claimPost("abandoned"))
+	assert.False(t, p.claimPost("abandoned"), "the new claim is fresh")
+}
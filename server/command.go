@@ -1,95 +1,99 @@
 package main
 
 import (
-	"context"
-	"fmt"
-	"io"
 	"strings"
 
 	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
 )
 
+const muCommandTrigger = "mu"
+
 // GetCommand تعریف دستور /mu برای پلاگین MuChat را بازمی‌گرداند.
+// زیر‌دستور access فقط برای مدیران سیستم در تکمیل خودکار نمایش داده می‌شود.
 func GetCommand() *model.Command {
+	autocomplete := model.NewAutocompleteData(muCommandTrigger, "[پیام شما]", "ارسال پیام به عامل MuChat")
+	access := model.NewAutocompleteData(accessCommand, "", "مدیریت دسترسی به دستیار")
+	access.RoleID = model.SystemAdminRoleId
+	for _, sub := range []struct{ trigger, hint, desc string }{
+		{accessAllow, "<team> <~channel> <@user>...", "مجاز کردن تیم، کانال یا کاربر"},
+		{accessBlock, "<team> <~channel> <@user>...", "مسدود کردن تیم، کانال یا کاربر"},
+		{accessRemove, "<team> <~channel> <@user>...", "حذف قاعدهٔ دسترسی"},
+		{accessList, "", "نمایش قواعد دسترسی"},
+	} {
+		access.AddCommand(model.NewAutocompleteData(sub.trigger, sub.hint, sub.desc))
+	}
+	autocomplete.AddCommand(access)
+
 	return &model.Command{
-		Trigger:          "mu",
+		Trigger:          muCommandTrigger,
 		AutoComplete:     true,
 		AutoCompleteDesc: "ارسال پیام به عامل MuChat",
 		AutoCompleteHint: "[پیام شما]",
+		AutocompleteData: autocomplete,
 	}
 }
 
-// ExecuteCommand اجرای دستور /mu را مدیریت می‌کند.
-// ctx: کانتکست برای مدیریت تایم‌اوت و لغو درخواست
+// ExecuteCommand اجرای دستورهای ثبت‌شدهٔ پلاگین را مدیریت می‌کند.
+// /mu در همین‌جا اجرا می‌شود و بقیهٔ دستورها به commandClient سپرده می‌شوند.
 // args: آرگومان‌های دستور شامل متن پیام
-func (p *Plugin) ExecuteCommand(ctx context.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
-	if strings.TrimSpace(args.Command) == "/mu" {
-		return &model.CommandResponse{}, &model.AppError{
-			Message: "لطفاً یک پیام وارد کنید.",
+func (p *Plugin) ExecuteCommand(_ *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := strings.Fields(args.Command)
+	if len(fields) == 0 || strings.TrimPrefix(fields[0], "/") != muCommandTrigger {
+		response, err := p.commandClient.Handle(args)
+		if err != nil {
+			return nil, model.NewAppError("ExecuteCommand", "plugin.command.execute_command.app_error", nil, err.Error(), 0)
 		}
+		return response, nil
 	}
 
-	message := strings.TrimPrefix(args.Command, "/mu ")
-	if message == "" {
-		return &model.CommandResponse{}, &model.AppError{
-			Message: "پیام نمی‌تواند خالی باشد.",
-		}
+	// /mu access ... مدیریت فهرست‌های دسترسی است، نه سؤال
+	if isAccessCommand(fields[1:]) {
+		return p.executeAccessCommand(args, fields[1:]), nil
 	}
 
-	// ارسال پیام "در حال تایپ..."
-	post := &model.Post{
-		ChannelId: args.ChannelId,
-		UserId:    args.UserId,
-		Message:   "در حال تایپ...",
-	}
-	createdPost, appErr := p.API.CreatePost(post)
-	if appErr != nil {
-		return nil, appErr
+	message := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args.Command), "/"+muCommandTrigger))
+	if message == "" {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "لطفاً یک پیام وارد کنید.",
+		}, nil
 	}
 
-	// ارسال پیام به MuChatClient
-	client := NewMuChatClient(p.configuration.MuChatApiKey)
-	response, err := client.Ask(ctx, p.configuration.AgentID, message, true)
-	if err != nil {
-		logError(p, err, "خطا در ارسال پیام به MuChat")
-		return nil, &model.AppError{
-			Message: fmt.Sprintf("خطا در ارتباط با MuChat: %v", err),
-		}
+	channel, chErr := p.API.GetChannel(args.ChannelId)
+	if chErr != nil {
+		return nil, chErr
 	}
-	defer response.Close()
-
-	// دریافت پاسخ به صورت استریم
-	var responseText strings.Builder
-	buf := make([]byte, 1024)
-	for {
-		bytesRead, readErr := response.Read(buf)
-		if bytesRead > 0 {
-			chunk := string(buf[:bytesRead])
-			responseText.WriteString(chunk)
 
-			// به‌روزرسانی پیام در حال تایپ
-			createdPost.Message = responseText.String()
-			if _, updateErr := p.API.UpdatePost(createdPost); updateErr != nil {
-				logError(p, updateErr, "خطا در به‌روزرسانی پیام")
-			}
-		}
-		if readErr == io.EOF {
-			break
-		}
-		if readErr != nil {
-			logError(p, readErr, "خطا در خواندن پاسخ استریم")
-			return nil, &model.AppError{
-				Message: fmt.Sprintf("خطا در خواندن پاسخ: %v", readErr),
-			}
-		}
+	// سیاست پاسخ نوع کانال و فهرست‌های دسترسی برای /mu هم اعمال می‌شوند
+	cfg := p.getConfiguration()
+	locale := p.userLocale(args.UserId)
+	if !p.isAuthorized(cfg, channel, args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         localize(locale, msgNotAllowed),
+		}, nil
 	}
 
-	// به‌روزرسانی پیام نهایی
-	createdPost.Message = responseText.String()
-	if _, updateErr := p.API.UpdatePost(createdPost); updateErr != nil {
-		logError(p, updateErr, "خطا در به‌روزرسانی پیام نهایی")
-	}
+	// ادامهٔ گفتگو در رشته یا پیام مستقیم
+	convKey := conversationKey(channel, cfg.replyPolicy(channel.Type), args.RootId, "")
 
-	logDebug(p, "دستور /mu با موفقیت اجرا شد.", "پیام", message)
+	// پاسخ در صف پردازش می‌شود و به‌صورت استریم در یک پست ربات نوشته می‌شود
+	p.enqueueQuestion(&question{
+		req: &AskRequest{
+			Query:     message,
+			UserID:    args.UserId,
+			ChannelID: args.ChannelId,
+			TeamID:    args.TeamId,
+		},
+		target: replyTarget{
+			UserID:    args.UserId,
+			ChannelID: args.ChannelId,
+			RootID:    args.RootId,
+			Locale:    locale,
+		},
+		convKey:  convKey,
+		createAt: model.GetMillis(),
+	})
 	return &model.CommandResponse{}, nil
 }
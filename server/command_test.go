@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/command"
+)
+
+// the server only calls ExecuteCommand if it has the hook's signature
+var _ interface {
+	ExecuteCommand(*plugin.Context, *model.CommandArgs) (*model.CommandResponse, *model.AppError)
+} = (*Plugin)(nil)
+
+type helloCommand struct {
+	command.Command
+	handled []string
+}
+
+func (c *helloCommand) Handle(args *model.CommandArgs) (*model.CommandResponse, error) {
+	c.handled = append(c.handled, args.Command)
+	return &model.CommandResponse{Text: "hello"}, nil
+}
+
+func TestExecuteCommandPassesOtherCommandsOn(t *testing.T) {
+	hello := &helloCommand{}
+	p := &Plugin{commandClient: hello}
+
+	response, appErr := p.ExecuteCommand(&plugin.Context{}, &model.CommandArgs{Command: "/hello @alice"})
+	require.Nil(t, appErr)
+	assert.Equal(t, "hello", response.Text)
+	assert.Equal(t, []string{"/hello @alice"}, hello.handled)
+
+	response, appErr = p.ExecuteCommand(&plugin.Context{}, &model.CommandArgs{Command: "/mu"})
+	require.Nil(t, appErr)
+	assert.Equal(t, model.CommandResponseTypeEphemeral, response.ResponseType)
+	assert.Len(t, hello.handled, 1)
+}
@@ -20,6 +20,86 @@ type Configuration struct {
 	AgentID      string
 	EnableDebug  bool
 
+	/* ──────────────── انتخاب بک‌اند ──────────────── */
+	Backend       string // muchat | openai | echo
+	OpenAIBaseURL string // آدرس پایهٔ API سازگار با OpenAI (مثلاً http://llm.local/v1)
+	OpenAIApiKey  string
+	OpenAIModel   string
+
+	/* ──────────────── اتصال شبکه (پراکسی، TLS، تایم‌اوت) ──────────────── */
+	MuChatBaseURL         string // پیش‌فرض https://app.mu.chat
+	ProxyURL              string // خالی = متغیرهای محیطی HTTP(S)_PROXY
+	CACertificate         string // PEM؛ به CAهای سیستم اضافه می‌شود
+	ClientCertificate     string // PEM
+	ClientKey             string // PEM
+	ConnectTimeoutSeconds int
+	ReadTimeoutSeconds    int
+
+	/* ──────────────── تلاش مجدد برای خطاهای گذرا ──────────────── */
+	RetryMaxAttempts     int // ۰ = پیش‌فرض (۳)، منفی = بدون تلاش مجدد
+	RetryBaseDelayMs     int
+	RetryMaxDelaySeconds int
+
+	/* ──────────────── قطع‌کنندهٔ مدار (circuit breaker) ──────────────── */
+	BreakerFailureThreshold int
+	BreakerCooldownSeconds  int
+	UnavailableMessage      string // پاسخ کاربر هنگام باز بودن مدار
+
+	MaxConcurrentRequests int // سقف درخواست‌های هم‌زمان به بک‌اند در کل پلاگین
+
+	/* ──────────────── محدودیت نرخ و سهمیهٔ روزانه (۰ = نامحدود) ──────────────── */
+	UserRequestsPerMinute    int
+	UserRequestsPerDay       int
+	ChannelRequestsPerMinute int
+	ChannelRequestsPerDay    int
+	RateLimitExemptRoles     string // comma-sep نقش‌های معاف، مانند system_admin
+
+	/* ──────────────── صف پردازش سؤال‌ها ──────────────── */
+	WorkerCount int // تعداد workerها؛ سؤال‌های هر کانال به ترتیب پردازش می‌شوند
+	QueueSize   int // ظرفیت کل صف؛ در صورت پر بودن پاسخ «مشغول هستم» داده می‌شود
+
+	/* ──────────────── بازخورد هنگام پاسخ‌گویی ──────────────── */
+	EnableTypingIndicator   bool // نمایش «در حال نوشتن» ربات تا پایان پاسخ
+	EnableProgressReactions bool // واکنش ⏳ روی سؤال و جایگزینی آن با ✅ یا ⚠️
+
+	/* ──────────────── زمینهٔ رشته (thread) ──────────────── */
+	EnableThreadContext   bool // ارسال پیام‌های قبلی رشته همراه سؤال
+	ThreadContextMaxChars int  // بودجهٔ کاراکتری پیام‌های قبلی
+
+	/* ──────────────── فایل‌های پیوست سؤال ──────────────── */
+	EnableFileContext     bool // ارسال متن فایل‌های پیوست همراه سؤال
+	FileContextMaxKB      int  // حداکثر حجم هر فایل
+	FileContextMaxTotalKB int  // حداکثر حجم کل فایل‌های یک سؤال
+
+	/* ──────────────── بازتولید پاسخ پس از ویرایش سؤال ──────────────── */
+	EnableRegenerateOnEdit    bool
+	RegenerateCooldownSeconds int // حداقل فاصلهٔ دو بازتولید برای یک سؤال
+
+	/* ──────────────── پاسخ سؤال‌های حذف‌شده ──────────────── */
+	DeletedQuestionReplies string // delete | redact
+
+	/* ──────────────── پاسخ‌های طولانی ──────────────── */
+	LongAnswerMode string // split | file
+
+	/* ──────────────── نمایش منابع پاسخ ──────────────── */
+	CitationStyle          string // footnotes | attachment | hidden
+	CitationHiddenChannels string // comma-sep ChannelID که منابع در آن‌ها نمایش داده نمی‌شود
+
+	/* ──────────────── سیاست پاسخ بر اساس نوع کانال ──────────────── */
+	DirectMessagePolicy  string // all | mention | never
+	GroupMessagePolicy   string // all | mention | never
+	PrivateChannelPolicy string // all | mention | never
+	PublicChannelPolicy  string // all | mention | never
+
+	/* ──────────────── تشخیص منشن ربات ──────────────── */
+	MentionByDisplayName bool   // نام نمایشی ربات هم منشن حساب شود
+	MentionAliases       string // comma-sep کلمه‌های جایگزین برای صدا زدن ربات
+
+	/* ──────────────── فیلدهای دسترسی تیم (پیش از کانال و کاربر) ──────────────── */
+	TeamAccess    string // allow_all | allow_selected | block_selected
+	TeamAllowList string // comma-sep TeamID؛ کانال‌های این تیم‌ها خودکار مجازند
+	TeamBlockList string // comma-sep TeamID
+
 	/* ──────────────── فیلدهای دسترسی کانال ──────────────── */
 	ChannelAccess     string // allow_all | allow_selected | block_selected | block_all
 	ChannelAllowList  string // رشتهٔ comma-sep از ChannelID
@@ -30,23 +110,59 @@ type Configuration struct {
 	UserAllowList  string // comma-sep UserID
 	UserBlockList  string // comma-sep UserID
 
+	/* ──────────────── دسترسی بر اساس نقش و گروه کاربر ──────────────── */
+	RoleAllowList  string // comma-sep نقش‌ها مانند system_admin, team_admin, channel_user
+	RoleBlockList  string // comma-sep نقش‌ها مانند system_guest
+	GroupAllowList string // comma-sep نام یا شناسهٔ گروه (سفارشی یا LDAP)
+	GroupBlockList string // comma-sep نام یا شناسهٔ گروه
+
 	/* فیلدهای محاسبه‌شده (هنگام OnConfigurationChange پر می‌شوند) */
+	TeamAllowIDs    []string `json:"-"`
+	TeamBlockIDs    []string `json:"-"`
 	ChannelAllowIDs []string `json:"-"`
 	ChannelBlockIDs []string `json:"-"`
 	UserAllowIDs    []string `json:"-"`
 	UserBlockIDs    []string `json:"-"`
+	RoleAllowNames  []string `json:"-"`
+	RoleBlockNames  []string `json:"-"`
+	GroupAllowNames []string `json:"-"`
+	GroupBlockNames []string `json:"-"`
+
+	RateLimitExemptRoleNames []string `json:"-"`
+
+	CitationHiddenChannelIDs []string `json:"-"`
+	MentionAliasList         []string `json:"-"`
 }
 
 /* Clone: deep copy شامل sliceها */
 func (c *Configuration) Clone() *Configuration {
 	var clone = *c
+	clone.TeamAllowIDs = append([]string(nil), c.TeamAllowIDs...)
+	clone.TeamBlockIDs = append([]string(nil), c.TeamBlockIDs...)
 	clone.ChannelAllowIDs = append([]string(nil), c.ChannelAllowIDs...)
 	clone.ChannelBlockIDs = append([]string(nil), c.ChannelBlockIDs...)
 	clone.UserAllowIDs = append([]string(nil), c.UserAllowIDs...)
 	clone.UserBlockIDs = append([]string(nil), c.UserBlockIDs...)
+	clone.RoleAllowNames = append([]string(nil), c.RoleAllowNames...)
+	clone.RoleBlockNames = append([]string(nil), c.RoleBlockNames...)
+	clone.GroupAllowNames = append([]string(nil), c.GroupAllowNames...)
+	clone.GroupBlockNames = append([]string(nil), c.GroupBlockNames...)
+	clone.RateLimitExemptRoleNames = append([]string(nil), c.RateLimitExemptRoleNames...)
+	clone.CitationHiddenChannelIDs = append([]string(nil), c.CitationHiddenChannelIDs...)
+	clone.MentionAliasList = append([]string(nil), c.MentionAliasList...)
 	return &clone
 }
 
+const defaultUnavailableMessage = "دستیار موقتاً در دسترس نیست. لطفاً چند دقیقهٔ دیگر دوباره تلاش کنید."
+
+/* unavailableMessage: پیام حالت افت سرویس یا مقدار پیش‌فرض */
+func (c *Configuration) unavailableMessage() string {
+	if msg := strings.TrimSpace(c.UnavailableMessage); msg != "" {
+		return msg
+	}
+	return defaultUnavailableMessage
+}
+
 /* ─────────────────────────── دسترسی thread-safe ─────────────────────────── */
 
 func (p *Plugin) getConfiguration() *Configuration {
@@ -91,11 +207,31 @@ func (p *Plugin) OnConfigurationChange() error {
 		return out
 	}
 
+	cfg.TeamAllowIDs = split(cfg.TeamAllowList)
+	cfg.TeamBlockIDs = split(cfg.TeamBlockList)
 	cfg.ChannelAllowIDs = split(cfg.ChannelAllowList)
 	cfg.ChannelBlockIDs = split(cfg.ChannelBlockList)
 	cfg.UserAllowIDs = split(cfg.UserAllowList)
 	cfg.UserBlockIDs = split(cfg.UserBlockList)
+	cfg.RoleAllowNames = split(cfg.RoleAllowList)
+	cfg.RoleBlockNames = split(cfg.RoleBlockList)
+	cfg.GroupAllowNames = split(cfg.GroupAllowList)
+	cfg.GroupBlockNames = split(cfg.GroupBlockList)
+	cfg.RateLimitExemptRoleNames = split(cfg.RateLimitExemptRoles)
+	cfg.CitationHiddenChannelIDs = split(cfg.CitationHiddenChannels)
+	cfg.MentionAliasList = split(cfg.MentionAliases)
+
+	// صف سؤال‌ها به تنظیمات اتصال وابسته نیست و پیش از بک‌اند ساخته می‌شود
+	p.applyWorkerConfiguration(cfg)
+
+	// بک‌اند مشترک فقط در صورت تغییر تنظیمات مربوط دوباره ساخته می‌شود؛
+	// خطای تنظیمات اتصال مانع اعمال بقیهٔ تنظیمات (دسترسی، سیاست‌ها و ...) نمی‌شود
+	backendErr := p.applyBackendConfiguration(cfg)
 
 	p.setConfiguration(cfg)
+	if backendErr != nil {
+		logError(p, backendErr, "cannot apply connection settings")
+		return backendErr
+	}
 	return nil
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnConfigurationChangeWithInvalidConnectionSettings(t *testing.T) {
+	var next Configuration
+	api := &plugintest.API{}
+	api.On("LoadPluginConfiguration", mock.AnythingOfType("*main.Configuration")).Run(func(args mock.Arguments) {
+		*args.Get(0).(*Configuration) = next
+	}).Return(nil)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	defer p.stopWorkers()
+	ask := func() error {
+		backend, err := p.getBackend()
+		require.NoError(t, err)
+		_, err = backend.Ask(context.Background(), &AskRequest{Query: "ping"})
+		return err
+	}
+
+	// at startup the other settings still apply and questions fail with the
+	// configuration error
+	next = Configuration{Backend: backendEcho, CACertificate: "not a certificate", ChannelAccess: "block_all"}
+	assert.Error(t, p.OnConfigurationChange())
+	assert.Equal(t, "block_all", p.getConfiguration().ChannelAccess)
+	assert.NotNil(t, p.workers)
+	assert.Equal(t, ErrKindUnavailable, errorKind(ask()))
+	assert.Error(t, p.OnConfigurationChange(), "the same settings report the same error")
+
+	next = Configuration{Backend: backendEcho}
+	require.NoError(t, p.OnConfigurationChange())
+	assert.NoError(t, ask())
+
+	// later invalid settings keep the working backend
+	next = Configuration{Backend: "unknown", ChannelAccess: "allow_selected"}
+	assert.Error(t, p.OnConfigurationChange())
+	assert.Equal(t, "allow_selected", p.getConfiguration().ChannelAccess)
+	assert.NoError(t, ask())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// conversationKey returns the key under which the backend conversation of a
+// question is stored: the whole channel for DMs, and for group messages in
+// which the bot answers every message; the thread root everywhere else. It is
+// empty for a top-level question without a post, such as /mu.
+func conversationKey(channel *model.Channel, policy, rootID, postID string) string {
+	if channel.Type == model.ChannelTypeDirect ||
+		(channel.Type == model.ChannelTypeGroup && policy == replyAll) {
+		return channel.Id
+	}
+	if rootID != "" {
+		return rootID
+	}
+	return postID
+}
+
+// loadConversationID returns the stored conversation ID for key, or an empty
+// string if there is none or it cannot be read.
+func (p *Plugin) loadConversationID(key string) string {
+	if key == "" {
+		return ""
+	}
+	conversationID, err := p.kvstore.GetConversationID(key)
+	if err != nil {
+		logError(p, err, "cannot load conversation ID", "key", key)
+		return ""
+	}
+	return conversationID
+}
+
+// storeConversationID persists the conversation ID returned by the backend, or
+// the one that was sent if the backend returned none. It is stored after every
+// answer so that the conversation expires only once the thread goes idle.
+func (p *Plugin) storeConversationID(key, previous, current string) {
+	if current == "" {
+		current = previous
+	}
+	if key == "" || current == "" {
+		return
+	}
+	if err := p.kvstore.SetConversationID(key, current); err != nil {
+		logError(p, err, "cannot store conversation ID", "key", key)
+	}
+}
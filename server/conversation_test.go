@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+type conversationStore struct {
+	kvstore.KVStore
+	stored []string
+}
+
+func (s *conversationStore) SetConversationID(key, conversationID string) error {
+	s.stored = append(s.stored, key+"="+conversationID)
+	return nil
+}
+
+func TestStoreConversationIDRefreshesExpiry(t *testing.T) {
+	store := &conversationStore{}
+	p := &Plugin{kvstore: store}
+
+	p.storeConversationID("thread", "", "c1")   // new conversation
+	p.storeConversationID("thread", "c1", "c1") // follow-up restarts the TTL
+	p.storeConversationID("thread", "c1", "")   // backend returned no ID
+	p.storeConversationID("", "", "c2")         // no post to key it by
+	assert.Equal(t, []string{"thread=c1", "thread=c1", "thread=c1"}, store.stored)
+}
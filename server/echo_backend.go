@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// EchoBackend is a local, deterministic backend that answers with the question
+// itself. It needs no network access and is meant for testing and staging.
+type EchoBackend struct{}
+
+// NewEchoBackend returns an echo backend.
+func NewEchoBackend() *EchoBackend {
+	return &EchoBackend{}
+}
+
+func (b *EchoBackend) reply(req *AskRequest) string {
+	return "echo: " + strings.TrimSpace(req.Query)
+}
+
+// Ask returns the query prefixed with "echo: " and keeps the conversation ID.
+func (b *EchoBackend) Ask(ctx context.Context, req *AskRequest) (*Answer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &Answer{Text: b.reply(req), ConversationID: req.ConversationID}, nil
+}
+
+// Stream returns the same answer as Ask.
+func (b *EchoBackend) Stream(ctx context.Context, req *AskRequest) (AnswerStream, error) {
+	answer, err := b.Ask(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return newStaticStream(answer), nil
+}
+
+// Health always succeeds.
+func (b *EchoBackend) Health(context.Context) error {
+	return nil
+}
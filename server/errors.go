@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrorKind classifies backend failures so callers can react to them.
+type ErrorKind string
+
+const (
+	ErrKindUnauthorized  ErrorKind = "unauthorized"
+	ErrKindQuotaExceeded ErrorKind = "quota_exceeded"
+	ErrKindTimeout       ErrorKind = "timeout"
+	ErrKindUpstream      ErrorKind = "upstream"
+	ErrKindMalformed     ErrorKind = "malformed_response"
+	ErrKindCanceled      ErrorKind = "canceled"
+	ErrKindUnavailable   ErrorKind = "unavailable"
+)
+
+// BackendError is returned by all backends. Use errors.As to inspect it:
+//
+//	var be *BackendError
+//	if errors.As(err, &be) && be.Kind == ErrKindQuotaExceeded { ... }
+type BackendError struct {
+	Kind       ErrorKind
+	StatusCode int    // HTTP status, 0 if the failure happened before a response
+	Message    string // message sent by the backend, if any
+	Err        error  // underlying error, if any
+}
+
+func (e *BackendError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("backend error (")
+	sb.WriteString(string(e.Kind))
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&sb, ", status %d", e.StatusCode)
+	}
+	sb.WriteString(")")
+	if e.Message != "" {
+		sb.WriteString(": ")
+		sb.WriteString(e.Message)
+	}
+	if e.Err != nil {
+		sb.WriteString(": ")
+		sb.WriteString(e.Err.Error())
+	}
+	return sb.String()
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// errorKind returns the kind of err, or ErrKindUpstream for unclassified errors.
+func errorKind(err error) ErrorKind {
+	var be *BackendError
+	if errors.As(err, &be) {
+		return be.Kind
+	}
+	if errors.Is(err, errCircuitOpen) {
+		return ErrKindUnavailable
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrKindCanceled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrKindTimeout
+	}
+	return ErrKindUpstream
+}
+
+// statusError builds the error for a non-2xx response and closes its body.
+func statusError(resp *http.Response) *BackendError {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+
+	kind := ErrKindUpstream
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		kind = ErrKindUnauthorized
+	case http.StatusPaymentRequired, http.StatusTooManyRequests:
+		kind = ErrKindQuotaExceeded
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		kind = ErrKindTimeout
+	}
+	return &BackendError{
+		Kind:       kind,
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+	}
+}
+
+// transportError classifies an error returned by http.Client.Do.
+func transportError(err error) *BackendError {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return &BackendError{Kind: ErrKindCanceled, Err: err}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &BackendError{Kind: ErrKindTimeout, Err: err}
+	default:
+		return &BackendError{Kind: ErrKindUpstream, Err: err}
+	}
+}
+
+// malformedError wraps a response decoding failure.
+func malformedError(err error) *BackendError {
+	return &BackendError{Kind: ErrKindMalformed, Err: err}
+}
+
+// streamError wraps an error event or a failure while reading a stream. Errors
+// caused by the request context keep their cancellation or timeout kind.
+func streamError(err error) *BackendError {
+	var be *BackendError
+	if errors.As(err, &be) {
+		return be
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return transportError(err)
+	}
+	return &BackendError{Kind: ErrKindUpstream, Err: err}
+}
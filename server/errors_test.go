@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackendErrorKinds(t *testing.T) {
+	for status, kind := range map[int]ErrorKind{
+		http.StatusUnauthorized:        ErrKindUnauthorized,
+		http.StatusForbidden:           ErrKindUnauthorized,
+		http.StatusTooManyRequests:     ErrKindQuotaExceeded,
+		http.StatusGatewayTimeout:      ErrKindTimeout,
+		http.StatusInternalServerError: ErrKindUpstream,
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nope", status)
+		}))
+		client := NewMuChatClient(server.URL, "key", "agent", server.Client())
+
+		_, err := client.Ask(context.Background(), &AskRequest{Query: "hi"})
+		server.Close()
+
+		var be *BackendError
+		require.True(t, errors.As(err, &be), "status %d", status)
+		assert.Equal(t, kind, be.Kind, "status %d", status)
+		assert.Equal(t, status, be.StatusCode)
+		assert.Equal(t, "nope", be.Message)
+	}
+}
+
+func TestBackendErrorMalformedAndCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not json"))
+	}))
+	defer server.Close()
+	client := NewMuChatClient(server.URL, "key", "agent", server.Client())
+
+	_, err := client.Ask(context.Background(), &AskRequest{Query: "hi"})
+	assert.Equal(t, ErrKindMalformed, errorKind(err))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.Ask(ctx, &AskRequest{Query: "hi"})
+	assert.Equal(t, ErrKindCanceled, errorKind(err))
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -1,6 +1,25 @@
 package main
 
+import (
+	"context"
+	"time"
+)
+
+// runJob checks periodically that the configured backend is reachable so that
+// broken credentials or endpoints show up in the server logs.
 func (p *Plugin) runJob() {
-	// Include job logic here
-	p.API.LogInfo("Job is currently running")
+	backend, err := p.getBackend()
+	if err != nil {
+		logError(p, err, "backend unavailable")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := backend.Health(ctx); err != nil {
+		p.API.LogWarn("Backend health check failed", "backend", p.getConfiguration().Backend, "error", err.Error())
+		return
+	}
+	logDebug(p, "backend health check passed")
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultMaxConcurrentRequests = 16
+
+var (
+	// errDraining is returned for requests started after the plugin began shutting down.
+	errDraining = errors.New("plugin is shutting down")
+
+	// errNoSlot wraps the context error of a request that gave up waiting for
+	// a free slot.
+	errNoSlot = errors.New("no free request slot")
+)
+
+// notSent reports whether err stopped a request before it reached the
+// backend: the circuit breaker was open, the plugin was shutting down, or no
+// request slot became free in time.
+func notSent(err error) bool {
+	return errors.Is(err, errCircuitOpen) || errors.Is(err, errDraining) || errors.Is(err, errNoSlot)
+}
+
+// requestLimiter caps the number of concurrent backend requests across the
+// plugin and tracks them so they can be drained on deactivation. The zero
+// value is not usable; call setLimit first.
+type requestLimiter struct {
+	mu       sync.Mutex
+	sem      chan struct{}
+	draining bool
+	inflight sync.WaitGroup
+}
+
+// setLimit changes the concurrency cap. Requests already running keep their
+// slot in the previous semaphore.
+func (l *requestLimiter) setLimit(limit int) {
+	if limit <= 0 {
+		limit = defaultMaxConcurrentRequests
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sem == nil || cap(l.sem) != limit {
+		l.sem = make(chan struct{}, limit)
+	}
+}
+
+// acquire waits for a free slot and returns the function releasing it.
+func (l *requestLimiter) acquire(ctx context.Context) (func(), error) {
+	l.mu.Lock()
+	if l.draining {
+		l.mu.Unlock()
+		return nil, &BackendError{Kind: ErrKindUnavailable, Err: errDraining}
+	}
+	sem := l.sem
+	l.inflight.Add(1)
+	l.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		l.inflight.Done()
+		return nil, transportError(fmt.Errorf("%w: %w", errNoSlot, ctx.Err()))
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-sem
+			l.inflight.Done()
+		})
+	}, nil
+}
+
+// drain rejects new requests and waits up to timeout for running ones. It
+// reports whether all requests finished in time.
+func (l *requestLimiter) drain(timeout time.Duration) bool {
+	l.mu.Lock()
+	l.draining = true
+	l.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		l.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// limitedBackend runs every request of a Backend through a requestLimiter.
+type limitedBackend struct {
+	Backend
+	limiter *requestLimiter
+}
+
+func (b *limitedBackend) Ask(ctx context.Context, req *AskRequest) (*Answer, error) {
+	release, err := b.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return b.Backend.Ask(ctx, req)
+}
+
+// Stream holds the slot until the returned stream is closed.
+func (b *limitedBackend) Stream(ctx context.Context, req *AskRequest) (AnswerStream, error) {
+	release, err := b.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := b.Backend.Stream(ctx, req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &releasingStream{AnswerStream: stream, release: release}, nil
+}
+
+// releasingStream releases its limiter slot when closed.
+type releasingStream struct {
+	AnswerStream
+	release func()
+}
+
+func (s *releasingStream) Close() error {
+	defer s.release()
+	return s.AnswerStream.Close()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// acquireWithin tries to take a slot of l for at most timeout.
+func acquireWithin(l *requestLimiter, timeout time.Duration) (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return l.acquire(ctx)
+}
+
+func TestRequestLimiterCapsConcurrency(t *testing.T) {
+	l := &requestLimiter{}
+	l.setLimit(2)
+
+	first, err := acquireWithin(l, time.Second)
+	require.NoError(t, err)
+	_, err = acquireWithin(l, time.Second)
+	require.NoError(t, err)
+
+	// the third request gives up when its context ends and was never sent
+	_, err = acquireWithin(l, 20*time.Millisecond)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, errNoSlot)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.True(t, notSent(err))
+
+	// releasing twice frees a single slot
+	first()
+	first()
+	_, err = acquireWithin(l, time.Second)
+	require.NoError(t, err)
+	_, err = acquireWithin(l, 20*time.Millisecond)
+	assert.ErrorIs(t, err, errNoSlot)
+}
+
+func TestRequestLimiterSetLimit(t *testing.T) {
+	l := &requestLimiter{}
+	l.setLimit(0)
+	assert.Equal(t, defaultMaxConcurrentRequests, cap(l.sem))
+
+	l.setLimit(1)
+	running, err := acquireWithin(l, time.Second)
+	require.NoError(t, err)
+
+	// a new limit applies to new requests; the running one keeps its slot in
+	// the previous semaphore and releases it there
+	l.setLimit(2)
+	_, err = acquireWithin(l, time.Second)
+	require.NoError(t, err)
+	_, err = acquireWithin(l, time.Second)
+	require.NoError(t, err)
+	running()
+	_, err = acquireWithin(l, 20*time.Millisecond)
+	assert.ErrorIs(t, err, errNoSlot)
+}
+
+func TestRequestLimiterDrain(t *testing.T) {
+	l := &requestLimiter{}
+	l.setLimit(2)
+	release, err := acquireWithin(l, time.Second)
+	require.NoError(t, err)
+
+	// a running request keeps drain waiting until the timeout
+	assert.False(t, l.drain(20*time.Millisecond))
+
+	// new requests are refused while draining
+	_, err = acquireWithin(l, time.Second)
+	assert.ErrorIs(t, err, errDraining)
+	assert.Equal(t, ErrKindUnavailable, errorKind(err))
+	assert.True(t, notSent(err))
+
+	time.AfterFunc(20*time.Millisecond, release)
+	assert.True(t, l.drain(time.Second))
+}
+
+func TestLimitedBackendStreamHoldsSlot(t *testing.T) {
+	l := &requestLimiter{}
+	l.setLimit(1)
+	backend := &limitedBackend{Backend: &streamBackend{text: "answer"}, limiter: l}
+
+	stream, err := backend.Stream(context.Background(), &AskRequest{})
+	require.NoError(t, err)
+
+	// the slot is held while the answer streams
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = backend.Stream(ctx, &AskRequest{})
+	assert.True(t, notSent(err))
+
+	require.NoError(t, stream.Close())
+	stream, err = backend.Stream(context.Background(), &AskRequest{})
+	require.NoError(t, err)
+	require.NoError(t, stream.Close())
+
+	// a failed stream gives its slot back at once
+	failing := &limitedBackend{Backend: &failingBackend{err: errors.New("refused")}, limiter: l}
+	_, err = failing.Stream(context.Background(), &AskRequest{})
+	assert.EqualError(t, err, "refused")
+	assert.True(t, l.drain(time.Second))
+}
+
+// failingBackend fails every request with err.
+type failingBackend struct {
+	Backend
+	err error
+}
+
+func (b *failingBackend) Stream(context.Context, *AskRequest) (AnswerStream, error) {
+	return nil, b.err
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Values of the "LongAnswerMode" setting.
+const (
+	longAnswerSplit = "split"
+	longAnswerFile  = "file"
+)
+
+// replyMaxRunes is the longest answer text put into one post. The rest of the
+// post size limit is left for citations and notes added to the text.
+const replyMaxRunes = model.PostMessageMaxRunesV2 - 1000
+
+// fileSummaryRunes bounds the excerpt shown above an answer attached as a file.
+const fileSummaryRunes = 1000
+
+// longAnswerFileName is the name of the file a long answer is attached as.
+const longAnswerFileName = "answer.md"
+
+// finishReply writes the final reply into writer's post. An answer too long
+// for one post is continued in further posts of the thread or, if configured,
+// attached as a markdown file. decorate is applied to the last post, or to the
+// summary post of a file, and may add up to reserve runes to its message. The
+// continuations of an earlier answer being regenerated are removed. It
+// returns the IDs of all reply posts.
+func (p *Plugin) finishReply(writer *postWriter, target replyTarget, reply string, reserve int, decorate func(*model.Post)) []string {
+	p.deletePreviousContinuations(target)
+
+	limit := replyMaxRunes - reserve
+	long := utf8.RuneCountInString(reply) > limit
+	if long && p.getConfiguration().LongAnswerMode == longAnswerFile {
+		if ids, ok := p.finishReplyAsFile(writer, target, reply, decorate); ok {
+			return ids
+		}
+	}
+
+	chunks := splitMarkdown(reply, limit)
+	last := len(chunks) - 1
+	firstDecorate := decorate
+	if last > 0 {
+		firstDecorate = nil
+	}
+	if appErr := writer.Finish(chunks[0], firstDecorate); appErr != nil {
+		logError(p, appErr, "cannot update reply")
+	}
+
+	ids := []string{writer.Post().Id}
+	for i, chunk := range chunks[1:] {
+		post := p.replyContinuation(target, writer.Post())
+		post.Message = chunk
+		if i+1 == last && decorate != nil {
+			decorate(post)
+		}
+		created, appErr := p.API.CreatePost(post)
+		if appErr != nil {
+			logError(p, appErr, "cannot create reply continuation", "part", i+2, "parts", len(chunks))
+			break
+		}
+		ids = append(ids, created.Id)
+	}
+	return ids
+}
+
+// deletePreviousContinuations removes the posts after the first of the earlier
+// answer a regenerated answer replaces. They are kept until the new answer is
+// complete, so that a failed regeneration leaves the earlier answer whole.
+func (p *Plugin) deletePreviousContinuations(target replyTarget) {
+	if len(target.ReplyPostIDs) < 2 {
+		return
+	}
+	for _, id := range target.ReplyPostIDs[1:] {
+		if appErr := p.API.DeletePost(id); appErr != nil {
+			logError(p, appErr, "cannot delete previous reply", "post_id", id)
+		}
+	}
+}
+
+// finishReplyAsFile attaches reply as a markdown file below a short summary in
+// writer's post. It reports false if the file could not be uploaded.
+func (p *Plugin) finishReplyAsFile(writer *postWriter, target replyTarget, reply string, decorate func(*model.Post)) ([]string, bool) {
+	info, appErr := p.API.UploadFile([]byte(reply), target.ChannelID, longAnswerFileName)
+	if appErr != nil {
+		logError(p, appErr, "cannot upload long answer")
+		return nil, false
+	}
+
+	summary := splitMarkdown(reply, fileSummaryRunes)[0]
+	summary += "\n\n…\n\n" + localize(target.Locale, msgAnswerAttached)
+	if appErr := writer.Finish(summary, decorate); appErr != nil {
+		logError(p, appErr, "cannot update reply")
+	}
+
+	ids := []string{writer.Post().Id}
+	post := p.replyContinuation(target, writer.Post())
+	post.FileIds = model.StringArray{info.Id}
+	created, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		logError(p, appErr, "cannot attach long answer")
+		return ids, true
+	}
+	return append(ids, created.Id), true
+}
+
+// replyContinuation returns a new bot post in the thread of the first reply.
+func (p *Plugin) replyContinuation(target replyTarget, first *model.Post) *model.Post {
+	rootID := target.RootID
+	if rootID == "" {
+		rootID = first.Id
+	}
+	return &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: target.ChannelID,
+		RootId:    rootID,
+	}
+}
+
+// splitMarkdown splits text into parts of at most limit runes. It prefers
+// paragraph boundaries, never splits inside a fenced code block or table
+// without closing and reopening it, and only breaks a line when the line
+// alone is too long.
+func splitMarkdown(text string, limit int) []string {
+	if utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+
+	var parts []string
+	var current string
+	flush := func() {
+		if current != "" {
+			parts = append(parts, current)
+			current = ""
+		}
+	}
+	for _, block := range markdownBlocks(text) {
+		size := utf8.RuneCountInString(block)
+		switch {
+		case current != "" && utf8.RuneCountInString(current)+2+size <= limit:
+			current += "\n\n" + block
+		case size <= limit:
+			flush()
+			current = block
+		default:
+			flush()
+			pieces := splitBlock(block, limit)
+			parts = append(parts, pieces[:len(pieces)-1]...)
+			current = pieces[len(pieces)-1]
+		}
+	}
+	flush()
+	return parts
+}
+
+// markdownBlocks returns the paragraphs of text: runs of lines separated by
+// blank lines outside fenced code blocks.
+func markdownBlocks(text string) []string {
+	var blocks, lines []string
+	var fence string
+	for _, line := range strings.Split(text, "\n") {
+		if fence == "" && strings.TrimSpace(line) == "" {
+			if len(lines) > 0 {
+				blocks = append(blocks, strings.Join(lines, "\n"))
+				lines = nil
+			}
+			continue
+		}
+		lines = append(lines, line)
+		fence = updateFence(fence, line)
+	}
+	if len(lines) > 0 {
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+	return blocks
+}
+
+// splitBlock splits a paragraph longer than limit at line boundaries. A code
+// fence open at a cut is closed and reopened, and a table's header is
+// repeated, so that every part renders on its own.
+func splitBlock(block string, limit int) []string {
+	var parts, lines []string
+	size := 0
+	add := func(line string) {
+		if len(lines) > 0 {
+			size++
+		}
+		lines = append(lines, line)
+		size += utf8.RuneCountInString(line)
+	}
+
+	var fence, opener, tableHeader string
+	prev := ""
+	for _, line := range wrapLines(block, limit/3, limit) {
+		next := updateFence(fence, line)
+		reserve := 0
+		if next != "" {
+			reserve = 1 + len(next)
+		}
+
+		if len(lines) > 0 && size+1+utf8.RuneCountInString(line)+reserve > limit {
+			part := strings.Join(lines, "\n")
+			if fence != "" {
+				part += "\n" + fence
+			}
+			parts = append(parts, part)
+			lines, size = nil, 0
+			switch {
+			case fence != "":
+				add(opener)
+			case tableHeader != "" && strings.Contains(line, "|"):
+				add(tableHeader)
+			}
+		}
+
+		if fence == "" {
+			switch {
+			case next != "":
+				opener = line
+			case isTableDelimiter(line) && strings.Contains(prev, "|"):
+				tableHeader = prev + "\n" + line
+			case !strings.Contains(line, "|"):
+				tableHeader = ""
+			}
+		}
+		add(line)
+		fence = next
+		prev = line
+	}
+	return append(parts, strings.Join(lines, "\n"))
+}
+
+// isTableDelimiter reports whether line is the row below a table header, such
+// as "| --- | :-: |".
+func isTableDelimiter(line string) bool {
+	line = strings.TrimSpace(line)
+	if !strings.Contains(line, "|") || !strings.Contains(line, "-") {
+		return false
+	}
+	return strings.Trim(line, "|:- ") == ""
+}
+
+// wrapLines returns the lines of text, breaking lines longer than limit runes
+// at the last space before the limit, or at the limit if there is none. Lines
+// of fenced code blocks are never wrapped; only a code line too long for a
+// part of partLimit runes, between the fence's opening and closing lines, is
+// cut where the part ends.
+func wrapLines(text string, limit, partLimit int) []string {
+	var out []string
+	var fence, opener string
+	for _, line := range strings.Split(text, "\n") {
+		next := updateFence(fence, line)
+		if fence == "" && next != "" {
+			opener = line
+		}
+		if fence != "" || next != "" {
+			codeLimit := max(partLimit-utf8.RuneCountInString(opener)-len(next)-2, 1)
+			for utf8.RuneCountInString(line) > codeLimit {
+				cut := runeOffset(line, codeLimit)
+				out = append(out, line[:cut])
+				line = line[cut:]
+			}
+			out = append(out, line)
+			fence = next
+			continue
+		}
+		for utf8.RuneCountInString(line) > limit {
+			cut := runeOffset(line, limit)
+			if i := strings.LastIndexByte(line[:cut], ' '); i > 0 {
+				cut = i
+			}
+			out = append(out, line[:cut])
+			line = strings.TrimPrefix(line[cut:], " ")
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+// runeOffset returns the byte offset of the n-th rune of s.
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
+	}
+	return len(s)
+}
+
+// truncateRunes shortens s to at most limit runes, marking the cut with an
+// ellipsis.
+func truncateRunes(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	return s[:runeOffset(s, limit-1)] + "…"
+}
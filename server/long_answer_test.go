@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitMarkdown(t *testing.T) {
+	t.Run("short text stays whole", func(t *testing.T) {
+		assert.Equal(t, []string{"hello\n\nworld"}, splitMarkdown("hello\n\nworld", 100))
+	})
+
+	t.Run("splits between paragraphs", func(t *testing.T) {
+		text := strings.Repeat("a", 30) + "\n\n" + strings.Repeat("b", 30) + "\n\n" + strings.Repeat("c", 30)
+		parts := splitMarkdown(text, 70)
+		assert.Equal(t, []string{
+			strings.Repeat("a", 30) + "\n\n" + strings.Repeat("b", 30),
+			strings.Repeat("c", 30),
+		}, parts)
+	})
+
+	t.Run("keeps a code block with blank lines together", func(t *testing.T) {
+		code := "```go\nfunc a() {}\n\nfunc b() {}\n```"
+		parts := splitMarkdown("intro\n\n"+code+"\n\noutro", len(code)+2)
+		assert.Equal(t, []string{"intro", code, "outro"}, parts)
+	})
+
+	t.Run("closes and reopens a long code block", func(t *testing.T) {
+		var lines []string
+		for i := 0; i < 20; i++ {
+			lines = append(lines, "line "+strings.Repeat("x", 10))
+		}
+		code := "```sh\n" + strings.Join(lines, "\n") + "\n```"
+		parts := splitMarkdown(code, 80)
+		require.Greater(t, len(parts), 1)
+		for _, part := range parts {
+			assert.True(t, strings.HasPrefix(part, "```sh\n"), part)
+			assert.True(t, strings.HasSuffix(part, "\n```"), part)
+			assert.LessOrEqual(t, utf8.RuneCountInString(part), 80)
+		}
+	})
+
+	t.Run("never wraps code lines", func(t *testing.T) {
+		var lines []string
+		for i := 0; i < 6; i++ {
+			lines = append(lines, "x := fmt.Sprintf(\"%d %s\", n, strings.Repeat(\"-\", 10))")
+		}
+		code := "```go\n" + strings.Join(lines, "\n") + "\n```"
+		parts := splitMarkdown(code, 90)
+		require.Greater(t, len(parts), 1)
+		var got []string
+		for _, part := range parts {
+			assert.LessOrEqual(t, utf8.RuneCountInString(part), 90)
+			part = strings.TrimSuffix(strings.TrimPrefix(part, "```go\n"), "\n```")
+			got = append(got, strings.Split(part, "\n")...)
+		}
+		assert.Equal(t, lines, got)
+	})
+
+	t.Run("cuts a code line longer than a post", func(t *testing.T) {
+		line := strings.Repeat("a b ", 50)
+		parts := splitMarkdown("```\n"+line+"\n```", 90)
+		require.Greater(t, len(parts), 1)
+		var got string
+		for _, part := range parts {
+			assert.LessOrEqual(t, utf8.RuneCountInString(part), 90)
+			got += strings.TrimSuffix(strings.TrimPrefix(part, "```\n"), "\n```")
+		}
+		assert.Equal(t, line, got)
+	})
+
+	t.Run("repeats the header of a long table", func(t *testing.T) {
+		table := "| name | value |\n| --- | --- |"
+		for i := 0; i < 10; i++ {
+			table += "\n| key | " + strings.Repeat("v", 10) + " |"
+		}
+		parts := splitMarkdown(table, 90)
+		require.Greater(t, len(parts), 1)
+		for _, part := range parts {
+			assert.True(t, strings.HasPrefix(part, "| name | value |\n| --- | --- |\n"), part)
+			assert.LessOrEqual(t, utf8.RuneCountInString(part), 90)
+		}
+	})
+
+	t.Run("breaks an overlong line at spaces", func(t *testing.T) {
+		text := strings.Repeat("word ", 100)
+		parts := splitMarkdown(text, 90)
+		require.Greater(t, len(parts), 1)
+		for _, part := range parts {
+			assert.LessOrEqual(t, utf8.RuneCountInString(part), 90)
+		}
+		assert.Equal(t, strings.Fields(text), strings.Fields(strings.Join(parts, " ")))
+	})
+
+	t.Run("counts runes, not bytes", func(t *testing.T) {
+		text := strings.Repeat("سلام ", 20)
+		assert.Len(t, splitMarkdown(text, 100), 1)
+	})
+}
+
+func TestTruncateRunes(t *testing.T) {
+	assert.Equal(t, "abc", truncateRunes("abc", 3))
+	assert.Equal(t, "ab…", truncateRunes("abcd", 3))
+	assert.Equal(t, "سل…", truncateRunes("سلام", 3))
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// mentionParser finds mentions of the bot in a message. Mentions inside code
+// blocks, code spans and blockquotes do not count, and a trigger only matches
+// as a whole word, so "@muchat" does not match "@muchat-admin".
+type mentionParser struct {
+	triggers []string // lowercased
+}
+
+// newMentionParser returns a parser for the bot's @username, its <@userID>
+// form and additional aliases such as the display name or trigger words.
+func newMentionParser(username, userID string, aliases []string) *mentionParser {
+	triggers := []string{"@" + strings.ToLower(username), "<@" + strings.ToLower(userID) + ">"}
+	for _, alias := range aliases {
+		if alias = strings.ToLower(strings.TrimSpace(alias)); alias != "" {
+			triggers = append(triggers, alias)
+		}
+	}
+	return &mentionParser{triggers: triggers}
+}
+
+// mentionSpan is the byte range [start, end) of a mention in a message.
+type mentionSpan struct {
+	start, end int
+}
+
+// Parse reports whether message mentions the bot and returns the message with
+// those mentions removed. Text in which the bot is not mentioned, such as
+// code or quotes, is left untouched.
+func (m *mentionParser) Parse(message string) (bool, string) {
+	spans := m.find(message)
+	if len(spans) == 0 {
+		return false, message
+	}
+
+	var sb strings.Builder
+	last := 0
+	for _, span := range spans {
+		start, end := span.start, span.end
+		// "(@muchat) question" loses the brackets left empty
+		if start > 0 && end < len(message) {
+			if closing, ok := mentionBrackets[message[start-1]]; ok && message[end] == closing {
+				start--
+				end++
+			}
+		}
+		// "@muchat: question" and "@muchat. question" lose the punctuation too
+		if r, size := utf8.DecodeRuneInString(message[end:]); strings.ContainsRune(mentionPunctuation, r) {
+			end += size
+		}
+		switch {
+		case end == len(message) || strings.IndexByte("\r\n)]}", message[end]) >= 0:
+			// "question @muchat." and "(ask @muchat)" leave no space before the
+			// end of the line or the bracket
+			if start > last && message[start-1] == ' ' {
+				start--
+			}
+		case message[end] == ' ' && (start == 0 || message[start-1] == ' ' || message[start-1] == '\n'):
+			// avoid a double space where the mention was
+			end++
+		}
+		sb.WriteString(message[last:start])
+		last = end
+	}
+	sb.WriteString(message[last:])
+	return true, sb.String()
+}
+
+// mentionBrackets maps the opening brackets that can enclose a mention to
+// their closing ones.
+var mentionBrackets = map[byte]byte{'(': ')', '[': ']', '{': '}'}
+
+// mentionPunctuation are the marks removed together with a mention they
+// directly follow.
+const mentionPunctuation = ":,.;!?،؛؟"
+
+// find returns the mentions in the parts of message that are normal text.
+func (m *mentionParser) find(message string) []mentionSpan {
+	var spans []mentionSpan
+	var fence string
+	indented, prevBlank := false, true
+
+	offset := 0
+	for _, line := range strings.SplitAfter(message, "\n") {
+		lineStart := offset
+		offset += len(line)
+		content := strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimLeft(content, " \t")
+		width := indentWidth(content[:len(content)-len(trimmed)])
+		blank := trimmed == ""
+
+		switch {
+		case fence != "" || updateFence("", content) != "":
+			// fenced code block, from the opening to the closing fence
+			fence = updateFence(fence, content)
+		case blank:
+		case width >= 4 && (prevBlank || indented):
+			// indented code block, such as a pasted log
+			indented = true
+		case strings.HasPrefix(trimmed, ">"):
+			// blockquote
+		default:
+			spans = append(spans, m.findInLine(trimmed, lineStart+len(content)-len(trimmed))...)
+		}
+
+		if !blank && width < 4 {
+			indented = false
+		}
+		prevBlank = blank
+	}
+	return spans
+}
+
+// indentWidth returns the width of leading whitespace, counting a tab as four
+// spaces.
+func indentWidth(ws string) int {
+	width := 0
+	for _, r := range ws {
+		if r == '\t' {
+			width += 4
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// updateFence returns the code fence that is open after line, given the one
+// open before it ("" outside fenced code blocks).
+func updateFence(fence, line string) string {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) >= 4 {
+		return fence
+	}
+	trimmed = strings.TrimSpace(trimmed)
+	if fence != "" {
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			return ""
+		}
+		return fence
+	}
+	if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+		return openingFence(trimmed)
+	}
+	return ""
+}
+
+// openingFence returns the run of backticks or tildes that opens a code block.
+func openingFence(line string) string {
+	n := 0
+	for n < len(line) && line[n] == line[0] {
+		n++
+	}
+	return line[:n]
+}
+
+// findInLine returns the mentions in a line of normal text that starts at
+// byte offset base of the message, skipping code spans.
+func (m *mentionParser) findInLine(line string, base int) []mentionSpan {
+	var spans []mentionSpan
+	for i := 0; i < len(line); {
+		if line[i] == '`' {
+			// a code span ends at the next run of the same number of backticks
+			run := openingFence(line[i:])
+			if end := strings.Index(line[i+len(run):], run); end >= 0 {
+				i += len(run) + end + len(run)
+				continue
+			}
+			i += len(run)
+			continue
+		}
+
+		if n := m.matchAt(line, i); n > 0 {
+			spans = append(spans, mentionSpan{start: base + i, end: base + i + n})
+			i += n
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(line[i:])
+		i += size
+	}
+	return spans
+}
+
+// matchAt returns the byte length of the trigger that matches at byte i of
+// line as a whole word, or 0. Case folding can change the byte length of a
+// rune, as for the Kelvin sign "K", so the match is measured in line itself.
+func (m *mentionParser) matchAt(line string, i int) int {
+	if i > 0 {
+		prev, _ := utf8.DecodeLastRuneInString(line[:i])
+		if isMentionRune(prev) || prev == '@' {
+			return 0
+		}
+	}
+	for _, trigger := range m.triggers {
+		n, ok := hasPrefixFold(line[i:], trigger)
+		if !ok || mentionContinues(line[i+n:]) {
+			continue
+		}
+		return n
+	}
+	return 0
+}
+
+// hasPrefixFold reports whether s begins with prefix under Unicode case
+// folding, and the byte length of the matching part of s.
+func hasPrefixFold(s, prefix string) (int, bool) {
+	n := 0
+	for _, want := range prefix {
+		got, size := utf8.DecodeRuneInString(s[n:])
+		if size == 0 || !strings.EqualFold(string(got), string(want)) {
+			return 0, false
+		}
+		n += size
+	}
+	return n, true
+}
+
+// mentionContinues reports whether rest continues the word before it, as in
+// the "-admin" of "@muchat-admin". A trailing dot ends a sentence, not a
+// username, unless a word character follows it.
+func mentionContinues(rest string) bool {
+	r, size := utf8.DecodeRuneInString(rest)
+	switch {
+	case size == 0:
+		return false
+	case r == '.':
+		next, _ := utf8.DecodeRuneInString(rest[size:])
+		return isMentionRune(next)
+	default:
+		return isMentionRune(r) || r == '-'
+	}
+}
+
+// isMentionRune reports whether r can be part of a username or word.
+func isMentionRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
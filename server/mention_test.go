@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMentionParser(t *testing.T) {
+	parser := newMentionParser("muchat", "botid", []string{"MuChat Bot", "دستیار"})
+
+	for name, tc := range map[string]struct {
+		message   string
+		mentioned bool
+		stripped  string
+	}{
+		"plain mention":        {"@muchat what is Go?", true, "what is Go?"},
+		"case insensitive":     {"hi @MuChat, what is Go?", true, "hi what is Go?"},
+		"colon separator":      {"@muchat: what is Go?", true, "what is Go?"},
+		"end of sentence":      {"what is Go @muchat.", true, "what is Go"},
+		"sentence after":       {"@muchat. hi", true, "hi"},
+		"question mark":        {"is it up @muchat?\nthanks", true, "is it up\nthanks"},
+		"persian comma":        {"دستیار، سلام", true, "سلام"},
+		"brackets":             {"(@muchat) q", true, "q"},
+		"brackets and comma":   {"hi [@muchat], q", true, "hi q"},
+		"brackets with text":   {"(ask @muchat) q", true, "(ask) q"},
+		"user ID form":         {"<@botid> what is Go?", true, "what is Go?"},
+		"lookalike username":   {"ask @muchat-admin or @muchat2", false, "ask @muchat-admin or @muchat2"},
+		"email address":        {"mail support@muchat.com", false, "mail support@muchat.com"},
+		"no mention":           {"what is Go?", false, "what is Go?"},
+		"code span":            {"run `@muchat ping` twice", false, "run `@muchat ping` twice"},
+		"double backtick span": {"run ``a ` @muchat`` now", false, "run ``a ` @muchat`` now"},
+		"fenced code block":    {"```\nERROR @muchat failed\n```\nsee log", false, "```\nERROR @muchat failed\n```\nsee log"},
+		"tilde fence":          {"~~~log\n@muchat\n~~~", false, "~~~log\n@muchat\n~~~"},
+		"indented code block":  {"log:\n\n    @muchat timeout", false, "log:\n\n    @muchat timeout"},
+		"blockquote":           {"> @muchat said hi\nthanks", false, "> @muchat said hi\nthanks"},
+		"mention after quote":  {"> old text\n@muchat summarize", true, "> old text\nsummarize"},
+		"mention after code":   {"```\nx\n```\n@muchat explain", true, "```\nx\n```\nexplain"},
+		"display name alias":   {"MuChat Bot, what is Go?", true, "what is Go?"},
+		"persian alias":        {"دستیار سلام", true, "سلام"},
+		"alias inside a word":  {"دستیارها آمدند", false, "دستیارها آمدند"},
+		"kelvin sign":          {"KKKK hello @muchat there", true, "KKKK hello there"},
+		"dotted capital I":     {"İİİİİİ @muchat hi", true, "İİİİİİ hi"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mentioned, stripped := parser.Parse(tc.message)
+			assert.Equal(t, tc.mentioned, mentioned)
+			assert.Equal(t, tc.stripped, stripped)
+		})
+	}
+}
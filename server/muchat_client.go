@@ -1,14 +1,13 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -18,18 +17,30 @@ import (
    ساختار و سازندهٔ کلاینت
 */
 
+const defaultMuChatBaseURL = "https://app.mu.chat"
+
 type MuChatClient struct {
-	apiKey string
-	http   *http.Client
+	baseURL string
+	apiKey  string
+	agentID string
+	http    *http.Client
 }
 
 // NewMuChatClient یک نمونهٔ جدید از کلاینت می‌سازد.
-func NewMuChatClient(apiKey string) *MuChatClient {
+// baseURL خالی یعنی سرویس عمومی MuChat؛ httpClient خالی یعنی کلاینت پیش‌فرض با تایم‌اوت ۶۰ ثانیه.
+func NewMuChatClient(baseURL, apiKey, agentID string, httpClient *http.Client) *MuChatClient {
+	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultMuChatBaseURL
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 60 * time.Second}
+	}
 	return &MuChatClient{
-		apiKey: apiKey,
-		http: &http.Client{
-			Timeout: 60 * time.Second,
-		},
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		agentID: agentID,
+		http:    httpClient,
 	}
 }
 
@@ -38,75 +49,221 @@ func NewMuChatClient(apiKey string) *MuChatClient {
    مدل پاسخ موردنیاز
 */
 type muChatResponse struct {
-	Answer string `json:"answer"`
+	Answer         string         `json:"answer"`
+	ConversationID string         `json:"conversationId"`
+	MessageID      string         `json:"messageId"`
+	Sources        []muChatSource `json:"sources"`
+	Usage          *Usage         `json:"usage"`
 }
 
-/*
-Ask سؤال را به MuChat می‌فرستد و فقط **متن پاسخ** را برمی‌گرداند.
+// muChatSource یک سند منبع در پاسخ MuChat است.
+type muChatSource struct {
+	Text           string `json:"text"`
+	Title          string `json:"title"`
+	DatasourceName string `json:"datasource_name"`
+	SourceURL      string `json:"source_url"`
+	URL            string `json:"url"`
+}
 
-اگر `stream` false باشد:
-	• بدنهٔ JSON را کامل می‌خواند
-	• فیلد answer را استخراج می‌کند
-	• همان متن را به صورت Reader برمی‌گرداند
+// merge فیلدهای غیرخالی پاسخ را (به‌جز متن) در Answer می‌ریزد.
+func (r *muChatResponse) merge(a *Answer) {
+	if r.ConversationID != "" {
+		a.ConversationID = r.ConversationID
+	}
+	if r.MessageID != "" {
+		a.MessageID = r.MessageID
+	}
+	if r.Usage != nil {
+		a.Usage = *r.Usage
+	}
+	if len(r.Sources) > 0 {
+		a.Sources = a.Sources[:0]
+		for _, src := range r.Sources {
+			title := src.Title
+			if title == "" {
+				title = src.DatasourceName
+			}
+			link := src.SourceURL
+			if link == "" {
+				link = src.URL
+			}
+			a.Sources = append(a.Sources, Source{Title: title, URL: link, Snippet: src.Text})
+		}
+	}
+}
 
-اگر `stream` true باشد:
-	• رویدادهای SSE را خط‌به‌خط می‌خواند
-	• فقط رویداد `answer` را Unmarshal می‌کند
-	• توکن‌های پاسخ را به‌‌صورت پیوسته در یک Pipe می‌نویسد
-*/
-func (c *MuChatClient) Ask(ctx context.Context, agentID, query string, stream bool) (io.ReadCloser, error) {
-	url := fmt.Sprintf("https://app.mu.chat/api/agents/%s/query", agentID)
+// agentURL آدرس API عامل را می‌سازد؛ suffix مثلاً "/query" است.
+func (c *MuChatClient) agentURL(suffix string) string {
+	return fmt.Sprintf("%s/api/agents/%s%s", c.baseURL, url.PathEscape(c.agentID), suffix)
+}
 
-	payload, _ := json.Marshal(map[string]interface{}{
-		"query":  query,
+// query درخواست query را می‌فرستد و پاسخ HTTP موفق را برمی‌گرداند؛
+// خطاها از نوع *BackendError هستند.
+func (c *MuChatClient) query(ctx context.Context, req *AskRequest, stream bool) (*http.Response, error) {
+	body := map[string]interface{}{
+		"query":  queryWithHistory(req),
 		"stream": stream,
-	})
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	}
+	if req.ConversationID != "" {
+		body["conversationId"] = req.ConversationID
+	}
+	payload, _ := json.Marshal(body)
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.agentURL("/query"), bytes.NewReader(payload))
 	if err != nil {
-		return nil, fmt.Errorf("ساخت درخواست HTTP شکست خورد: %w", err)
+		return nil, fmt.Errorf("build HTTP request: %w", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-	req.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	httpReq.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.http.Do(req)
+	resp, err := c.http.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("ارسال HTTP شکست خورد: %w", err)
-	}
-	if resp.StatusCode == http.StatusForbidden {
-		return nil, errors.New("دسترسی غیرمجاز: کلید API نامعتبر است")
+		return nil, transportError(err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("خطای غیرمنتظره: %d", resp.StatusCode)
-	}
-
-	/*──────────── حالت استریم ────────────*/
-	if stream {
-		pr, pw := io.Pipe()
-		go func() {
-			defer resp.Body.Close()
-			defer pw.Close()
-
-			scanner := bufio.NewScanner(resp.Body)
-			for scanner.Scan() {
-				line := scanner.Text()
-				if strings.HasPrefix(line, "data:") {
-					var r muChatResponse
-					if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &r); err == nil {
-						if _, werr := pw.Write([]byte(r.Answer)); werr != nil {
-							return
-						}
-					}
-				}
-			}
-		}()
-		return pr, nil
+		return nil, statusError(resp)
 	}
+	return resp, nil
+}
 
-	/*──────────── حالت غیر استریم ────────────*/
+/*
+Ask سؤال را به MuChat می‌فرستد، بدنهٔ JSON را کامل می‌خواند و فیلد answer
+را برمی‌گرداند.
+*/
+func (c *MuChatClient) Ask(ctx context.Context, req *AskRequest) (*Answer, error) {
+	resp, err := c.query(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
+
 	var r muChatResponse
 	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
-		return nil, fmt.Errorf("دیکد JSON شکست خورد: %w", err)
+		return nil, malformedError(err)
+	}
+	answer := &Answer{Text: r.Answer}
+	r.merge(answer)
+	return answer, nil
+}
+
+/*
+Stream سؤال را در حالت استریم می‌فرستد:
+  - رویدادهای SSE را با sseDecoder می‌خواند (بدون محدودیت ۶۴KB خط)
+  - توکن‌های پاسخ را به‌صورت پیوسته در یک Pipe می‌نویسد
+  - رویدادهای خطا را به‌صورت error به خوانندهٔ Pipe می‌رساند
+  - شناسهٔ گفتگو، شناسهٔ پیام و منابع را برای Answer() نگه می‌دارد
+*/
+func (c *MuChatClient) Stream(ctx context.Context, req *AskRequest) (AnswerStream, error) {
+	resp, err := c.query(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := newPipeStream()
+	stream.update(func(a *Answer) { a.ConversationID = req.ConversationID })
+	go pumpSSE(resp.Body, stream, func(ev *sseEvent) (string, error) {
+		return handleMuChatEvent(ev, stream)
+	})
+	return stream, nil
+}
+
+// muChatStreamError شکل رویداد خطای استریم MuChat است.
+type muChatStreamError struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+}
+
+// muChatEvent شکل JSON یک رویداد استریم MuChat است.
+type muChatEvent struct {
+	muChatResponse
+	muChatStreamError
+}
+
+// muChatEventFields فیلدهایی است که MuChat در رویدادهای JSON می‌فرستد.
+var muChatEventFields = []string{"answer", "conversationId", "messageId", "sources", "usage", "error", "message"}
+
+// decode داده را به‌عنوان رویداد JSON می‌خواند. شیئی که هیچ‌یک از فیلدهای
+// MuChat را ندارد، مانند "{}" در یک قطعه کد، رویداد به حساب نمی‌آید.
+func (e *muChatEvent) decode(data string) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(data), &fields); err != nil {
+		return err
+	}
+	known := false
+	for _, name := range muChatEventFields {
+		if _, ok := fields[name]; ok {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return errors.New("no MuChat event fields")
+	}
+	return json.Unmarshal([]byte(data), e)
+}
+
+/*
+handleMuChatEvent یک رویداد SSE از MuChat را تفسیر می‌کند و متنی را که باید
+به پاسخ اضافه شود برمی‌گرداند:
+  - `[DONE]` پایان تمیز استریم است
+  - رویداد `error` (یا JSON دارای فیلد error) به خطا تبدیل می‌شود
+  - رویداد `endpoint_response`/`metadata` فقط متادیتا (شناسه‌ها و منابع) را به‌روز می‌کند
+  - دادهٔ JSON با فیلد answer، یا متن خام، توکن پاسخ است
+*/
+func handleMuChatEvent(ev *sseEvent, stream *pipeStream) (string, error) {
+	data := ev.Data
+	if strings.TrimSpace(data) == "[DONE]" {
+		return "", errStreamDone
+	}
+
+	if ev.Event == "error" {
+		var e muChatStreamError
+		if json.Unmarshal([]byte(data), &e) == nil && (e.Error != "" || e.Message != "") {
+			data = strings.TrimSpace(e.Error + " " + e.Message)
+		}
+		return "", &BackendError{Kind: ErrKindUpstream, Message: data}
+	}
+
+	token := ev.Event == "message" || ev.Event == "answer"
+	if !strings.HasPrefix(strings.TrimSpace(data), "{") {
+		if token {
+			return data, nil
+		}
+		return "", nil // رویداد ناشناخته و غیر JSON
+	}
+
+	var r muChatEvent
+	if err := r.decode(data); err != nil {
+		if token {
+			// توکن خامی مانند "{" در پاسخ‌های حاوی کد
+			return data, nil
+		}
+		return "", malformedError(fmt.Errorf("decode %q event: %w", ev.Event, err))
+	}
+	if r.Error != "" {
+		return "", &BackendError{Kind: ErrKindUpstream, Message: strings.TrimSpace(r.Error + " " + r.Message)}
+	}
+	stream.update(r.merge)
+	if ev.Event == "endpoint_response" || ev.Event == "metadata" {
+		return "", nil
+	}
+	return r.Answer, nil
+}
+
+// Health با خواندن مشخصات عامل، اعتبار کلید API و در دسترس بودن سرویس را بررسی می‌کند.
+func (c *MuChatClient) Health(ctx context.Context) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, c.agentURL(""), nil)
+	if err != nil {
+		return fmt.Errorf("build HTTP request: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return transportError(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
 	}
-	return io.NopCloser(strings.NewReader(r.Answer)), nil
+	resp.Body.Close()
+	return nil
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// OpenAIClient talks to any server implementing the OpenAI chat-completions API
+// (OpenAI itself, vLLM, Ollama, LocalAI, ...).
+type OpenAIClient struct {
+	baseURL string
+	apiKey  string
+	model   string
+	http    *http.Client
+}
+
+// NewOpenAIClient returns a chat-completions client. An empty baseURL selects the
+// public OpenAI endpoint and a nil httpClient a default client with a 60s timeout.
+func NewOpenAIClient(baseURL, apiKey, model string, httpClient *http.Client) *OpenAIClient {
+	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		baseURL = defaultOpenAIBaseURL
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 60 * time.Second}
+	}
+	return &OpenAIClient{
+		baseURL: baseURL,
+		apiKey:  apiKey,
+		model:   model,
+		http:    httpClient,
+	}
+}
+
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+}
+
+type openAIResponse struct {
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+		Delta   openAIMessage `json:"delta"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+}
+
+func (c *OpenAIClient) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("build HTTP request: %w", err)
+	}
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	return httpReq, nil
+}
+
+func (c *OpenAIClient) complete(ctx context.Context, req *AskRequest, stream bool) (*http.Response, error) {
+	messages := make([]openAIMessage, 0, len(req.History)+1)
+	for _, msg := range req.History {
+		content := msg.Text
+		if msg.Role == roleUser {
+			content = "@" + msg.Author + ": " + content
+		}
+		messages = append(messages, openAIMessage{Role: msg.Role, Content: content})
+	}
+	messages = append(messages, openAIMessage{Role: roleUser, Content: questionWithFiles(req)})
+
+	payload, _ := json.Marshal(openAIRequest{
+		Model:    c.model,
+		Messages: messages,
+		Stream:   stream,
+	})
+	httpReq, err := c.newRequest(ctx, http.MethodPost, "/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return nil, transportError(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	return resp, nil
+}
+
+// Ask sends the thread history and the question as a chat completion and
+// returns the first choice.
+func (c *OpenAIClient) Ask(ctx context.Context, req *AskRequest) (*Answer, error) {
+	resp, err := c.complete(ctx, req, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r openAIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, malformedError(err)
+	}
+	answer := &Answer{}
+	if r.Usage != nil {
+		answer.Usage = *r.Usage
+	}
+	if len(r.Choices) > 0 {
+		answer.Text = r.Choices[0].Message.Content
+	}
+	return answer, nil
+}
+
+// Stream sends a streaming chat completion and writes the content deltas to the
+// returned stream.
+func (c *OpenAIClient) Stream(ctx context.Context, req *AskRequest) (AnswerStream, error) {
+	resp, err := c.complete(ctx, req, true)
+	if err != nil {
+		return nil, err
+	}
+
+	stream := newPipeStream()
+	go pumpSSE(resp.Body, stream, func(ev *sseEvent) (string, error) {
+		return handleOpenAIEvent(ev, stream)
+	})
+	return stream, nil
+}
+
+// handleOpenAIEvent returns the content delta of a chat-completions chunk and
+// records the usage some servers send with the last chunk.
+func handleOpenAIEvent(ev *sseEvent, stream *pipeStream) (string, error) {
+	data := strings.TrimSpace(ev.Data)
+	if data == "[DONE]" {
+		return "", errStreamDone
+	}
+
+	var r struct {
+		openAIResponse
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		return "", malformedError(fmt.Errorf("decode stream chunk: %w", err))
+	}
+	if r.Error != nil {
+		return "", &BackendError{Kind: ErrKindUpstream, Message: r.Error.Message}
+	}
+	if r.Usage != nil {
+		stream.update(func(a *Answer) { a.Usage = *r.Usage })
+	}
+	if len(r.Choices) == 0 {
+		return "", nil
+	}
+	return r.Choices[0].Delta.Content, nil
+}
+
+// Health lists the available models to verify the endpoint and the API key.
+func (c *OpenAIClient) Health(ctx context.Context) error {
+	httpReq, err := c.newRequest(ctx, http.MethodGet, "/models", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(httpReq)
+	if err != nil {
+		return transportError(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewOpenAIClientBaseURL(t *testing.T) {
+	assert.Equal(t, defaultOpenAIBaseURL, NewOpenAIClient(" ", "", "m", nil).baseURL)
+	assert.Equal(t, "http://llm:8000/v1", NewOpenAIClient("http://llm:8000/v1/", "", "m", nil).baseURL)
+}
+
+func TestOpenAIClientAsk(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var req openAIRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, openAIRequest{
+			Model: "llama3",
+			Messages: []openAIMessage{
+				{Role: roleUser, Content: "@alice: deploy failed"},
+				{Role: roleAssistant, Content: "check the logs"},
+				{Role: roleUser, Content: "which logs?"},
+			},
+		}, req)
+
+		_, _ = w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"the app logs"}}],` +
+			`"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15}}`))
+	}))
+	defer server.Close()
+	client := NewOpenAIClient(server.URL+"/v1", "key", "llama3", server.Client())
+
+	answer, err := client.Ask(context.Background(), &AskRequest{
+		Query: "which logs?",
+		History: []ChatMessage{
+			{Role: roleUser, Author: "alice", Text: "deploy failed"},
+			{Role: roleAssistant, Author: "muchat", Text: "check the logs"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "the app logs", answer.Text)
+	assert.Equal(t, Usage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}, answer.Usage)
+}
+
+func TestOpenAIClientStream(t *testing.T) {
+	stream := func(t *testing.T, body string) (AnswerStream, string, error) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req openAIRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.True(t, req.Stream)
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = w.Write([]byte(body))
+		}))
+		t.Cleanup(server.Close)
+		client := NewOpenAIClient(server.URL, "", "llama3", server.Client())
+
+		s, err := client.Stream(context.Background(), &AskRequest{Query: "hi"})
+		require.NoError(t, err)
+		defer s.Close()
+		text, err := io.ReadAll(s)
+		return s, string(text), err
+	}
+
+	t.Run("deltas until done", func(t *testing.T) {
+		s, text, err := stream(t, ""+
+			"data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n"+
+			"data: {\"choices\":[{\"delta\":{\"content\":\"Hello\"}}]}\n\n"+
+			"data: {\"choices\":[{\"delta\":{\"content\":\" world\"}}]}\n\n"+
+			"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2,\"total_tokens\":7}}\n\n"+
+			"data: [DONE]\n\n"+
+			"data: {\"choices\":[{\"delta\":{\"content\":\" after done\"}}]}\n\n")
+		require.NoError(t, err)
+		assert.Equal(t, "Hello world", text)
+		assert.Equal(t, Usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7}, s.Answer().Usage)
+	})
+
+	t.Run("error chunk", func(t *testing.T) {
+		_, text, err := stream(t, ""+
+			"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n"+
+			"data: {\"error\":{\"message\":\"model overloaded\"}}\n\n")
+		assert.Equal(t, "Hel", text)
+		var be *BackendError
+		require.True(t, errors.As(err, &be))
+		assert.Equal(t, ErrKindUpstream, be.Kind)
+		assert.Equal(t, "model overloaded", be.Message)
+	})
+
+	t.Run("malformed chunk", func(t *testing.T) {
+		_, _, err := stream(t, "data: {\"choices\":\n\n")
+		assert.Equal(t, ErrKindMalformed, errorKind(err))
+	})
+}
+
+func TestOpenAIClientHealth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/models", r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "bad key", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	}))
+	defer server.Close()
+
+	assert.NoError(t, NewOpenAIClient(server.URL, "key", "m", server.Client()).Health(context.Background()))
+	err := NewOpenAIClient(server.URL, "wrong", "m", server.Client()).Health(context.Background())
+	assert.Equal(t, ErrKindUnauthorized, errorKind(err))
+}
+
+func TestEchoBackend(t *testing.T) {
+	backend := NewEchoBackend()
+	req := &AskRequest{Query: " ping ", ConversationID: "c1"}
+
+	answer, err := backend.Ask(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, &Answer{Text: "echo: ping", ConversationID: "c1"}, answer)
+
+	stream, err := backend.Stream(context.Background(), req)
+	require.NoError(t, err)
+	text, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	assert.Equal(t, "echo: ping", string(text))
+	assert.Equal(t, "c1", stream.Answer().ConversationID)
+	assert.NoError(t, stream.Close())
+	assert.NoError(t, backend.Health(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = backend.Stream(ctx, req)
+	assert.ErrorIs(t, err, context.Canceled)
+}
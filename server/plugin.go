@@ -3,8 +3,6 @@
 package main
 
 import (
-	"context"
-	"io"
 	"strings"
 	"sync"
 	"time"
@@ -40,8 +38,34 @@ type Plugin struct {
 	configurationLock sync.RWMutex
 	configuration     *Configuration
 
-	botUserID   string
-	botUsername string
+	// shared backend, rebuilt in OnConfigurationChange
+	backendLock sync.RWMutex
+	backend     *sharedBackend
+	breaker     circuitBreaker
+	limiter     requestLimiter
+
+	// usage records waiting to be written to the KV store
+	usage usageRecorder
+
+	// bounded queue of questions waiting for an answer
+	workersLock sync.RWMutex
+	workers     *workerPool
+
+	// edits waiting for the regeneration cooldown, by question post ID
+	regenerateTimersLock sync.Mutex
+	regenerateTimers     map[string]*time.Timer
+
+	// roles and groups of users, for role- and group-based access
+	principals principalCache
+
+	// access lists managed with /mu access, cached from the KV store
+	accessRulesLock     sync.RWMutex
+	accessRules         *kvstore.AccessRules
+	accessRulesLoadedAt time.Time
+
+	botUserID      string
+	botUsername    string
+	botDisplayName string
 }
 
 /*
@@ -55,6 +79,7 @@ func (p *Plugin) OnActivate() error {
 	p.client = pluginapi.NewClient(p.API, p.Driver)
 	p.kvstore = kvstore.NewKVStore(p.client)
 	p.commandClient = command.NewCommandHandler(p.client)
+	p.usage.start(p.writeUsage)
 
 	bot := &model.Bot{
 		Username:    "muchat",
@@ -67,9 +92,10 @@ func (p *Plugin) OnActivate() error {
 	}
 	p.botUserID = botID
 	p.botUsername = bot.Username
+	p.botDisplayName = bot.DisplayName
 
-	if err := p.API.RegisterCommand(command.GetCommand()); err != nil {
-		return err
+	if err := p.API.RegisterCommand(GetCommand()); err != nil {
+		return errors.Wrap(err, "cannot register /mu command")
 	}
 
 	job, err := cluster.Schedule(
@@ -85,6 +111,34 @@ func (p *Plugin) OnActivate() error {
 	return nil
 }
 
+/*
+───────────────────────────────
+
+	OnDeactivate
+
+───────────────────────────────
+*/
+func (p *Plugin) OnDeactivate() error {
+	if p.backgroundJob != nil {
+		if err := p.backgroundJob.Close(); err != nil {
+			logError(p, err, "cannot stop background job")
+		}
+	}
+
+	p.stopRegenerations()
+
+	// answer the queued questions, then let in-flight backend requests finish
+	// before the plugin process exits
+	p.stopWorkers()
+	if !p.limiter.drain(drainTimeout) {
+		p.API.LogWarn("Backend requests still running at deactivation", "timeout", drainTimeout.String())
+	}
+	if !p.usage.stop(drainTimeout) {
+		p.API.LogWarn("Usage records still being written at deactivation", "timeout", drainTimeout.String())
+	}
+	return nil
+}
+
 /*
 ───────────────────────────────
 
@@ -92,6 +146,13 @@ func (p *Plugin) OnActivate() error {
 
 ───────────────────────────────
 */
+// drainTimeout bounds how long OnDeactivate waits for in-flight requests.
+const drainTimeout = 30 * time.Second
+
+// defaultRegenerateCooldown is the minimum time between two regenerations of
+// the same answer.
+const defaultRegenerateCooldown = 10 * time.Second
+
 func contains(list []string, id string) bool {
 	for _, v := range list {
 		if v == id {
@@ -125,10 +186,34 @@ func isAllowed(id, mode string, allow, block []string, isChannel bool) bool {
 ───────────────────────────────
 */
 func (p *Plugin) MessageHasBeenPosted(_ *plugin.Context, post *model.Post) {
-	// ignore messages from the bot itself
-	if post.UserId == p.botUserID {
+	q := p.questionFromPost(post)
+	if q == nil {
 		return
 	}
+	// in a cluster only the node that claims the post answers it
+	if !p.claimPost(post.Id) {
+		return
+	}
+	q.claimed = true
+	p.enqueueQuestion(q)
+}
+
+// mentionParser returns the parser for mentions of the bot under cfg.
+func (p *Plugin) mentionParser(cfg *Configuration) *mentionParser {
+	aliases := cfg.MentionAliasList
+	if cfg.MentionByDisplayName && p.botDisplayName != "" {
+		aliases = append([]string{p.botDisplayName}, aliases...)
+	}
+	return newMentionParser(p.botUsername, p.botUserID, aliases)
+}
+
+// questionFromPost returns the question post asks the bot, or nil if the bot
+// should not answer it.
+func (p *Plugin) questionFromPost(post *model.Post) *question {
+	// ignore messages from the bot itself and system messages
+	if post.UserId == p.botUserID || post.IsSystemMessage() {
+		return nil
+	}
 
 	cfg := p.getConfiguration()
 
@@ -136,79 +221,189 @@ func (p *Plugin) MessageHasBeenPosted(_ *plugin.Context, post *model.Post) {
 	channel, chErr := p.API.GetChannel(post.ChannelId)
 	if chErr != nil {
 		logError(p, chErr, "cannot get channel")
-		return
+		return nil
 	}
 
 	// ignore if bot is not a member of the channel
 	if _, err := p.API.GetChannelMember(channel.Id, p.botUserID); err != nil {
+		return nil
+	}
+
+	// reply policy and access control checks
+	if !p.isAuthorized(cfg, channel, post.UserId) {
+		return nil
+	}
+	policy := cfg.replyPolicy(channel.Type)
+
+	// mention logic; mentions in code and quotes do not count and are kept
+	mentioned, message := p.mentionParser(cfg).Parse(post.Message)
+	if policy == replyMention && !mentioned {
+		return nil
+	}
+	// other bots must mention the bot, so that two bots never answer each other
+	if !mentioned && post.GetProp(model.PostPropsFromBot) == "true" {
+		return nil
+	}
+
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return nil
+	}
+
+	return &question{
+		req: &AskRequest{
+			Query:     message,
+			UserID:    post.UserId,
+			ChannelID: channel.Id,
+			TeamID:    channel.TeamId,
+		},
+		target: replyTarget{
+			UserID:    post.UserId,
+			ChannelID: post.ChannelId,
+			RootID:    replyRootID(post),
+			Locale:    p.userLocale(post.UserId),
+		},
+		convKey:  conversationKey(channel, policy, post.RootId, post.Id),
+		postID:   post.Id,
+		fileIDs:  post.FileIds,
+		createAt: post.CreateAt,
+	}
+}
+
+/*
+───────────────────────────────
+
+	MessageHasBeenDeleted
+
+───────────────────────────────
+*/
+func (p *Plugin) MessageHasBeenDeleted(_ *plugin.Context, post *model.Post) {
+	if post.UserId == p.botUserID {
 		return
 	}
+	p.retractReplies(post.Id, p.userLocale(post.UserId))
+}
 
-	// access control checks
-	if !isAllowed(channel.Id, cfg.ChannelAccess, cfg.ChannelAllowIDs, cfg.ChannelBlockIDs, true) {
+/*
+───────────────────────────────
+
+	MessageHasBeenUpdated
+
+───────────────────────────────
+*/
+func (p *Plugin) MessageHasBeenUpdated(_ *plugin.Context, newPost, oldPost *model.Post) {
+	// the bot's own streaming edits are never questions
+	if newPost.UserId == p.botUserID {
 		return
 	}
-	if !isAllowed(post.UserId, cfg.UserAccess, cfg.UserAllowIDs, cfg.UserBlockIDs, false) {
+	cfg := p.getConfiguration()
+	if !cfg.EnableRegenerateOnEdit || newPost.Message == oldPost.Message {
 		return
 	}
+	p.regenerateAnswer(newPost)
+}
 
-	isDM := channel.Type == model.ChannelTypeDirect
+// regenerateAnswer answers an edited question again in place of its earlier
+// answer. Edits during the cooldown of the last regeneration are debounced:
+// the question's latest text is regenerated once the cooldown ends.
+func (p *Plugin) regenerateAnswer(post *model.Post) {
+	cfg := p.getConfiguration()
 
-	// mention logic
-	mentioned := strings.Contains(post.Message, "@"+p.botUsername) ||
-		strings.Contains(post.Message, "<@"+p.botUserID+">")
+	// only posts the bot answered before are regenerated
+	reply, err := p.kvstore.GetReply(post.Id)
+	if err != nil {
+		logError(p, err, "cannot get reply", "post_id", post.Id)
+		return
+	}
+	if reply == nil || len(reply.ReplyPostIDs) == 0 {
+		return
+	}
 
-	if !isDM && !mentioned {
+	q := p.questionFromPost(post)
+	if q == nil {
 		return
 	}
 
-	// strip mention for non-DM messages
-	message := post.Message
-	if !isDM {
-		message = strings.ReplaceAll(message, "@"+p.botUsername, "")
-		message = strings.ReplaceAll(message, "<@"+p.botUserID+">", "")
+	// guard against edit storms: at most one regeneration per cooldown
+	cooldown := secondsOr(cfg.RegenerateCooldownSeconds, defaultRegenerateCooldown)
+	claimed, err := p.kvstore.ClaimRegeneration(post.Id, cooldown)
+	if err != nil {
+		logError(p, err, "cannot claim regeneration", "post_id", post.Id)
+		return
 	}
-	message = strings.TrimSpace(message)
-	if message == "" {
+	if !claimed {
+		logDebug(p, "regeneration deferred until the cooldown ends", "post_id", post.Id)
+		p.deferRegeneration(post.Id, cooldown)
 		return
 	}
+	// this regeneration covers edits that were waiting for the cooldown
+	if _, err := p.kvstore.TakeRegenerationPending(post.Id); err != nil {
+		logError(p, err, "cannot clear pending regeneration", "post_id", post.Id)
+	}
 
-	// call MuChat
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	q.target.ReplyPostIDs = reply.ReplyPostIDs
+	p.enqueueQuestion(q)
+}
 
-	rc, err := NewMuChatClient(cfg.MuChatApiKey).Ask(ctx, cfg.AgentID, message, false)
-	if err != nil {
-		logError(p, err, "MuChat request failed")
+// deferRegeneration marks the answer to postID for regeneration and checks
+// again after cooldown. The mark is shared by the cluster; the timer is not,
+// so at most one timer per post runs on each node.
+func (p *Plugin) deferRegeneration(postID string, cooldown time.Duration) {
+	// the mark outlives the timers of all nodes that may take it
+	if err := p.kvstore.SetRegenerationPending(postID, 3*cooldown); err != nil {
+		logError(p, err, "cannot defer regeneration", "post_id", postID)
 		return
 	}
-	defer rc.Close()
 
-	var sb strings.Builder
-	buf := make([]byte, 2048)
-	for {
-		n, rerr := rc.Read(buf)
-		if n > 0 {
-			sb.Write(buf[:n])
-		}
-		if rerr == io.EOF {
-			break
-		}
-		if rerr != nil {
-			logError(p, rerr, "read MuChat response")
-			return
-		}
+	p.regenerateTimersLock.Lock()
+	defer p.regenerateTimersLock.Unlock()
+	if p.regenerateTimers == nil {
+		p.regenerateTimers = map[string]*time.Timer{}
+	}
+	if _, ok := p.regenerateTimers[postID]; ok {
+		return
+	}
+	// the claim expires with a granularity of one second
+	p.regenerateTimers[postID] = time.AfterFunc(cooldown+time.Second, func() {
+		p.regenerateTimersLock.Lock()
+		delete(p.regenerateTimers, postID)
+		p.regenerateTimersLock.Unlock()
+		p.regeneratePending(postID)
+	})
+}
+
+// regeneratePending regenerates the answer to postID from the question's
+// current text if an edit is still waiting for the cooldown.
+func (p *Plugin) regeneratePending(postID string) {
+	if !p.getConfiguration().EnableRegenerateOnEdit {
+		return
+	}
+	pending, err := p.kvstore.TakeRegenerationPending(postID)
+	if err != nil {
+		logError(p, err, "cannot take pending regeneration", "post_id", postID)
+		return
+	}
+	if !pending {
+		return // regenerated meanwhile, on this or another node
 	}
 
-	reply := strings.TrimSpace(sb.String())
-	if reply == "" {
-		reply = "متأسفم، پاسخی دریافت نشد."
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		logError(p, appErr, "cannot get edited question", "post_id", postID)
+		return
+	}
+	if post.DeleteAt != 0 {
+		return
 	}
+	p.regenerateAnswer(post)
+}
 
-	_, _ = p.API.CreatePost(&model.Post{
-		UserId:    p.botUserID,
-		ChannelId: post.ChannelId,
-		RootId:    post.Id,
-		Message:   reply,
-	})
+// stopRegenerations cancels the deferred regenerations of this node.
+func (p *Plugin) stopRegenerations() {
+	p.regenerateTimersLock.Lock()
+	defer p.regenerateTimersLock.Unlock()
+	for postID, timer := range p.regenerateTimers {
+		timer.Stop()
+		delete(p.regenerateTimers, postID)
+	}
 }
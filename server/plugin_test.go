@@ -1,12 +1,21 @@
 package main
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
 )
 
 func TestServeHTTP(t *testing.T) {
@@ -27,3 +36,261 @@ func TestServeHTTP(t *testing.T) {
 
 	assert.Equal("Hello, world!", bodyString)
 }
+
+// replyStore keeps the replies, regeneration claims and conversations of the
+// question hooks in memory.
+type replyStore struct {
+	kvstore.KVStore
+
+	mu      sync.Mutex
+	replies map[string]*kvstore.Reply
+	reads   int  // calls of GetReply
+	cooling bool // a regeneration was claimed within the cooldown
+	pending map[string]bool
+}
+
+func newReplyStore() *replyStore {
+	return &replyStore{replies: map[string]*kvstore.Reply{}, pending: map[string]bool{}}
+}
+
+func (s *replyStore) GetReply(questionPostID string) (*kvstore.Reply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads++
+	return s.replies[questionPostID], nil
+}
+
+func (s *replyStore) SetReply(questionPostID string, reply *kvstore.Reply) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies[questionPostID] = reply
+	return nil
+}
+
+func (s *replyStore) DeleteReply(questionPostID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.replies, questionPostID)
+	return nil
+}
+
+func (s *replyStore) ClaimRegeneration(string, time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cooling {
+		return false, nil
+	}
+	s.cooling = true
+	return true, nil
+}
+
+func (s *replyStore) SetRegenerationPending(questionPostID string, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[questionPostID] = true
+	return nil
+}
+
+func (s *replyStore) TakeRegenerationPending(questionPostID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	taken := s.pending[questionPostID]
+	delete(s.pending, questionPostID)
+	return taken, nil
+}
+
+func (s *replyStore) GetConversationID(string) (string, error) { return "", nil }
+func (s *replyStore) SetConversationID(string, string) error   { return nil }
+func (s *replyStore) GetAccessRules() (*kvstore.AccessRules, error) {
+	return &kvstore.AccessRules{}, nil
+}
+
+// questionHooks is a plugin answering with the echo backend in a direct
+// message channel, where every post is a question. The question post has the
+// ID "question" and its earlier answer the ID "reply".
+type questionHooks struct {
+	*Plugin
+	api   *plugintest.API
+	store *replyStore
+
+	mu       sync.Mutex
+	question *model.Post
+	updates  []string
+}
+
+func newQuestionHooks(t *testing.T, cfg *Configuration) *questionHooks {
+	h := &questionHooks{
+		Plugin:   &Plugin{botUserID: "bot", botUsername: "muchat"},
+		api:      &plugintest.API{},
+		store:    newReplyStore(),
+		question: &model.Post{Id: "question", UserId: "user", ChannelId: "dm", Message: "v0"},
+	}
+	h.store.replies["question"] = &kvstore.Reply{ReplyPostIDs: []string{"reply"}}
+
+	h.api.On("GetChannel", "dm").Return(&model.Channel{Id: "dm", Type: model.ChannelTypeDirect}, nil)
+	h.api.On("GetChannelMember", "dm", "bot").Return(&model.ChannelMember{}, nil)
+	h.api.On("GetUser", "user").Return(&model.User{Id: "user", Locale: "en"}, nil)
+	h.api.On("GetPost", "question").Return(func(string) *model.Post {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return h.question.Clone()
+	}, nil)
+	h.api.On("GetPost", "reply").Return(&model.Post{Id: "reply", UserId: "bot", ChannelId: "dm", Message: "old answer"}, nil)
+	h.api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.updates = append(h.updates, post.Message)
+		return post.Clone()
+	}, nil)
+	h.api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	h.SetAPI(h.api)
+	h.kvstore = h.store
+	h.backend = &sharedBackend{backend: NewEchoBackend()}
+	h.setConfiguration(cfg)
+	h.applyWorkerConfiguration(cfg)
+	t.Cleanup(func() { h.stopRegenerations() })
+	return h
+}
+
+// edit changes the question's message and runs the update hook.
+func (h *questionHooks) edit(message string) {
+	h.mu.Lock()
+	old := h.question.Clone()
+	h.question.Message = message
+	edited := h.question.Clone()
+	h.mu.Unlock()
+	h.MessageHasBeenUpdated(nil, edited, old)
+}
+
+// answers waits for the queued questions and returns the final texts of the
+// regenerated answers.
+func (h *questionHooks) answers(t *testing.T) []string {
+	t.Helper()
+	h.stopWorkers()
+	h.applyWorkerConfiguration(h.getConfiguration())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var answers []string
+	for _, message := range h.updates {
+		if text, ok := strings.CutSuffix(message, "\n\n"+localize("en", msgRegenerated)); ok {
+			answers = append(answers, text)
+		}
+	}
+	return answers
+}
+
+func TestRegenerateOnEdit(t *testing.T) {
+	h := newQuestionHooks(t, &Configuration{EnableRegenerateOnEdit: true})
+
+	h.edit("v1")
+	assert.Equal(t, []string{"echo: v1"}, h.answers(t))
+
+	// edits during the cooldown wait for it to end
+	h.edit("v2")
+	h.edit("v3")
+	assert.Equal(t, []string{"echo: v1"}, h.answers(t))
+	assert.True(t, h.store.pending["question"])
+	assert.Len(t, h.regenerateTimers, 1, "one timer per post")
+
+	// once the cooldown has ended, the latest text is answered, once
+	h.store.cooling = false
+	h.regeneratePending("question")
+	h.regeneratePending("question")
+	assert.Equal(t, []string{"echo: v1", "echo: v3"}, h.answers(t))
+	assert.Empty(t, h.store.pending)
+}
+
+func TestIgnoreBotEdits(t *testing.T) {
+	h := newQuestionHooks(t, &Configuration{EnableRegenerateOnEdit: true})
+
+	edited := &model.Post{Id: "reply", UserId: "bot", ChannelId: "dm", Message: "old answer and more"}
+	h.MessageHasBeenUpdated(nil, edited, &model.Post{Id: "reply", UserId: "bot", ChannelId: "dm", Message: "old answer"})
+	assert.Empty(t, h.answers(t))
+	assert.Zero(t, h.store.reads)
+}
+
+func TestRetractOnDelete(t *testing.T) {
+	t.Run("delete", func(t *testing.T) {
+		h := newQuestionHooks(t, &Configuration{})
+		h.api.On("DeletePost", "reply").Return(nil).Once()
+
+		h.MessageHasBeenDeleted(nil, h.question)
+		h.api.AssertCalled(t, "DeletePost", "reply")
+		assert.Empty(t, h.store.replies)
+	})
+
+	t.Run("redact", func(t *testing.T) {
+		h := newQuestionHooks(t, &Configuration{DeletedQuestionReplies: retractRedact})
+
+		h.MessageHasBeenDeleted(nil, h.question)
+		assert.Equal(t, []string{localize("en", msgRedacted)}, h.updates)
+		h.api.AssertNotCalled(t, "DeletePost", mock.Anything)
+		assert.Empty(t, h.store.replies)
+	})
+
+	t.Run("retry after a failure", func(t *testing.T) {
+		h := newQuestionHooks(t, &Configuration{})
+		h.api.On("DeletePost", "reply").Return(model.NewAppError("DeletePost", "boom", nil, "", 500)).Once()
+
+		h.MessageHasBeenDeleted(nil, h.question)
+		assert.Contains(t, h.store.replies, "question", "the link is kept for a retry")
+	})
+
+	t.Run("deleted while answering", func(t *testing.T) {
+		h := newQuestionHooks(t, &Configuration{EnableRegenerateOnEdit: true})
+		h.api.On("DeletePost", "reply").Return(nil).Once()
+
+		// the question is gone by the time the regenerated answer is stored
+		h.question.DeleteAt = model.GetMillis()
+		h.regenerateAnswer(h.question)
+		h.answers(t)
+		h.api.AssertCalled(t, "DeletePost", "reply")
+		assert.Empty(t, h.store.replies)
+	})
+}
+
+func TestRegenerateSplitAnswer(t *testing.T) {
+	setup := func(t *testing.T, backend Backend) *questionHooks {
+		h := newQuestionHooks(t, &Configuration{EnableRegenerateOnEdit: true})
+		h.store.replies["question"] = &kvstore.Reply{ReplyPostIDs: []string{"reply", "reply-2"}}
+		h.backend = &sharedBackend{backend: backend}
+		h.api.On("DeletePost", "reply-2").Return(nil)
+		h.api.On("SendEphemeralPost", "user", mock.AnythingOfType("*model.Post")).Return(&model.Post{})
+		return h
+	}
+
+	t.Run("replaces all parts", func(t *testing.T) {
+		h := setup(t, NewEchoBackend())
+		h.edit("v1")
+		assert.Equal(t, []string{"echo: v1"}, h.answers(t))
+		h.api.AssertCalled(t, "DeletePost", "reply-2")
+		assert.Equal(t, []string{"reply"}, h.store.replies["question"].ReplyPostIDs)
+	})
+
+	t.Run("keeps all parts when it fails", func(t *testing.T) {
+		h := setup(t, &failingBackend{err: errors.New("refused")})
+		h.edit("v1")
+		h.answers(t)
+		h.api.AssertNotCalled(t, "DeletePost", mock.Anything)
+		assert.Equal(t, "old answer", h.updates[len(h.updates)-1])
+		assert.Equal(t, []string{"reply", "reply-2"}, h.store.replies["question"].ReplyPostIDs)
+		h.api.AssertCalled(t, "SendEphemeralPost", "user", mock.AnythingOfType("*model.Post"))
+	})
+}
+
+func TestRegenerateWhileUnavailable(t *testing.T) {
+	h := newQuestionHooks(t, &Configuration{EnableRegenerateOnEdit: true, UnavailableMessage: "down for maintenance"})
+	h.backend = &sharedBackend{backend: &failingBackend{err: &BackendError{Kind: ErrKindUnavailable, Err: errCircuitOpen}}}
+	var notices []string
+	h.api.On("SendEphemeralPost", "user", mock.AnythingOfType("*model.Post")).Return(func(_ string, post *model.Post) *model.Post {
+		notices = append(notices, post.Message)
+		return post
+	})
+
+	h.edit("v1")
+	h.answers(t)
+	assert.Equal(t, "old answer", h.updates[len(h.updates)-1], "the earlier answer is restored")
+	assert.Equal(t, []string{"down for maintenance"}, notices)
+}
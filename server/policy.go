@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Values of the per-channel-type reply policy settings.
+const (
+	replyAll     = "all"     // answer every message
+	replyMention = "mention" // answer only messages that mention the bot
+	replyNever   = "never"   // never answer, including /mu
+)
+
+// replyPolicy returns the reply policy for a type of channel. Direct messages
+// default to answering everything, all other channels to mentions only.
+func (c *Configuration) replyPolicy(channelType model.ChannelType) string {
+	var policy, fallback string
+	switch channelType {
+	case model.ChannelTypeDirect:
+		policy, fallback = c.DirectMessagePolicy, replyAll
+	case model.ChannelTypeGroup:
+		policy, fallback = c.GroupMessagePolicy, replyMention
+	case model.ChannelTypePrivate:
+		policy, fallback = c.PrivateChannelPolicy, replyMention
+	default:
+		policy, fallback = c.PublicChannelPolicy, replyMention
+	}
+
+	switch policy {
+	case replyAll, replyMention, replyNever:
+		return policy
+	default:
+		return fallback
+	}
+}
+
+// canAnswer reports whether the bot may answer userID in channel at all: the
+// channel type's reply policy is not "never" and the team, the channel and the
+// user pass the access lists, checked in that order.
+func (c *Configuration) canAnswer(channel *model.Channel, userID string) bool {
+	if c.replyPolicy(channel.Type) == replyNever {
+		return false
+	}
+	// DMs and group messages belong to no team
+	if channel.TeamId != "" && !isAllowed(channel.TeamId, c.TeamAccess, c.TeamAllowIDs, c.TeamBlockIDs, false) {
+		return false
+	}
+	if !c.channelAllowed(channel) {
+		return false
+	}
+	return isAllowed(userID, c.UserAccess, c.UserAllowIDs, c.UserBlockIDs, false)
+}
+
+// channelAllowed applies the channel access mode. With "allow_selected",
+// channels of an explicitly allowed team are allowed too, so that new
+// channels of the team need no configuration.
+func (c *Configuration) channelAllowed(channel *model.Channel) bool {
+	if isAllowed(channel.Id, c.ChannelAccess, c.ChannelAllowIDs, c.ChannelBlockIDs, true) {
+		return true
+	}
+	return c.ChannelAccess == "allow_selected" && c.TeamAccess == "allow_selected" &&
+		channel.TeamId != "" && contains(c.TeamAllowIDs, channel.TeamId)
+}
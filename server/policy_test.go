@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplyPolicy(t *testing.T) {
+	var defaults Configuration
+	assert.Equal(t, replyAll, defaults.replyPolicy(model.ChannelTypeDirect))
+	assert.Equal(t, replyMention, defaults.replyPolicy(model.ChannelTypeGroup))
+	assert.Equal(t, replyMention, defaults.replyPolicy(model.ChannelTypePrivate))
+	assert.Equal(t, replyMention, defaults.replyPolicy(model.ChannelTypeOpen))
+
+	cfg := Configuration{
+		DirectMessagePolicy:  "bogus",
+		GroupMessagePolicy:   replyAll,
+		PrivateChannelPolicy: replyNever,
+	}
+	assert.Equal(t, replyAll, cfg.replyPolicy(model.ChannelTypeDirect))
+	assert.Equal(t, replyAll, cfg.replyPolicy(model.ChannelTypeGroup))
+	assert.Equal(t, replyNever, cfg.replyPolicy(model.ChannelTypePrivate))
+}
+
+func TestCanAnswer(t *testing.T) {
+	cfg := Configuration{
+		PrivateChannelPolicy: replyNever,
+		UserAccess:           "block_selected",
+		UserBlockIDs:         []string{"blocked"},
+	}
+	public := &model.Channel{Id: "public", Type: model.ChannelTypeOpen}
+	private := &model.Channel{Id: "private", Type: model.ChannelTypePrivate}
+
+	assert.True(t, cfg.canAnswer(public, "user"))
+	assert.False(t, cfg.canAnswer(public, "blocked"))
+	assert.False(t, cfg.canAnswer(private, "user"))
+}
+
+func TestCanAnswerTeams(t *testing.T) {
+	cfg := Configuration{
+		TeamAccess:      "allow_selected",
+		TeamAllowIDs:    []string{"sales"},
+		ChannelAccess:   "allow_selected",
+		ChannelAllowIDs: []string{"listed"},
+	}
+	newSalesChannel := &model.Channel{Id: "new", TeamId: "sales", Type: model.ChannelTypeOpen}
+	listedOtherTeam := &model.Channel{Id: "listed", TeamId: "support", Type: model.ChannelTypeOpen}
+	dm := &model.Channel{Id: "dm", Type: model.ChannelTypeDirect}
+
+	// channels of an allowed team inherit access; other teams are blocked first
+	assert.True(t, cfg.canAnswer(newSalesChannel, "user"))
+	assert.False(t, cfg.canAnswer(listedOtherTeam, "user"))
+	// DMs belong to no team
+	assert.False(t, cfg.canAnswer(dm, "user"), "not in the channel allow list")
+
+	cfg.ChannelAccess = "block_selected"
+	cfg.ChannelBlockIDs = []string{"new"}
+	assert.False(t, cfg.canAnswer(newSalesChannel, "user"), "explicit channel block wins")
+	assert.True(t, cfg.canAnswer(dm, "user"))
+
+	cfg.TeamAccess = "block_selected"
+	cfg.TeamBlockIDs = []string{"support"}
+	assert.False(t, cfg.canAnswer(listedOtherTeam, "user"))
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const (
+	// streamMinInterval is the minimum time between two edits of a streamed reply.
+	streamMinInterval = 800 * time.Millisecond
+	// streamMinDelta is the minimum number of new bytes that justifies an edit.
+	streamMinDelta = 64
+)
+
+// postWriter streams text into a bot post. The post is created up front as a
+// placeholder; written text is applied with throttled edits and Finish does
+// the final, unconditional update.
+type postWriter struct {
+	api  plugin.API
+	post *model.Post
+
+	minInterval time.Duration
+	minDelta    int
+	maxRunes    int // longer text is shown truncated while streaming
+
+	// original is the message of a resumed post, restored by Discard
+	original *string
+
+	mu        sync.Mutex
+	text      strings.Builder
+	flushed   int // length of the text at the last edit
+	lastFlush time.Time
+}
+
+// finalUpdateAttempts is how often Finish tries to apply the final text.
+const finalUpdateAttempts = 3
+
+// newPostWriter creates the placeholder post and returns a writer editing it.
+func newPostWriter(api plugin.API, post *model.Post) (*postWriter, error) {
+	created, appErr := api.CreatePost(post)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return &postWriter{
+		api:         api,
+		post:        created,
+		minInterval: streamMinInterval,
+		minDelta:    streamMinDelta,
+		maxRunes:    replyMaxRunes,
+		lastFlush:   time.Now(),
+	}, nil
+}
+
+// resumePostWriter returns a writer that overwrites an existing post, showing
+// placeholder until the first edit.
+func resumePostWriter(api plugin.API, post *model.Post, placeholder string) (*postWriter, error) {
+	original := post.Message
+	post = post.Clone()
+	post.Message = placeholder
+	updated, appErr := api.UpdatePost(post)
+	if appErr != nil {
+		return nil, appErr
+	}
+	return &postWriter{
+		api:         api,
+		post:        updated,
+		original:    &original,
+		minInterval: streamMinInterval,
+		minDelta:    streamMinDelta,
+		maxRunes:    replyMaxRunes,
+		lastFlush:   time.Now(),
+	}, nil
+}
+
+// Write appends b to the reply and edits the post if enough text arrived since
+// the last edit and enough time has passed. Edit failures are not returned so
+// that a slow server never aborts the stream; Finish retries with the full text.
+func (w *postWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.text.Write(b)
+	if w.text.Len()-w.flushed >= w.minDelta && time.Since(w.lastFlush) >= w.minInterval {
+		w.post.Message = truncateRunes(w.text.String(), w.maxRunes)
+		if w.updateLocked() == nil {
+			w.flushed = w.text.Len()
+		}
+	}
+	return len(b), nil
+}
+
+// Len returns the number of bytes written so far.
+func (w *postWriter) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.text.Len()
+}
+
+// Text returns the text written so far.
+func (w *postWriter) Text() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.text.String()
+}
+
+// Post returns the post being written.
+func (w *postWriter) Post() *model.Post {
+	return w.post
+}
+
+func (w *postWriter) updateLocked() *model.AppError {
+	updated, appErr := w.api.UpdatePost(w.post)
+	w.lastFlush = time.Now()
+	if appErr != nil {
+		return appErr
+	}
+	w.post = updated
+	return nil
+}
+
+// Finish sets the post message and lets decorate (if not nil) adjust the post,
+// e.g. to add citations, before the final update. The update is retried a few
+// times because it is the only one guaranteed to carry the complete answer.
+func (w *postWriter) Finish(message string, decorate func(*model.Post)) *model.AppError {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.post.Message = message
+	if decorate != nil {
+		decorate(w.post)
+	}
+	var appErr *model.AppError
+	for attempt := 0; attempt < finalUpdateAttempts; attempt++ {
+		if appErr = w.updateLocked(); appErr == nil {
+			w.flushed = w.text.Len()
+			return nil
+		}
+	}
+	return appErr
+}
+
+// Discard deletes the placeholder post, or restores the original message of
+// a resumed post.
+func (w *postWriter) Discard() *model.AppError {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.original != nil {
+		w.post.Message = *w.original
+		return w.updateLocked()
+	}
+	return w.api.DeletePost(w.post.Id)
+}
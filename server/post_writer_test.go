@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostWriter(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	var edits []string
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		created := post.Clone()
+		created.Id = "reply"
+		return created
+	}, nil)
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		edits = append(edits, post.Message)
+		return post.Clone()
+	}, nil)
+
+	w, appErr := newPostWriter(api, &model.Post{ChannelId: "channel", Message: "..."})
+	require.Nil(t, appErr)
+	w.minInterval = time.Hour
+	w.minDelta = 4
+
+	// nothing is edited before the minimum interval has passed
+	_, _ = w.Write([]byte("Hello"))
+	assert.Empty(t, edits)
+
+	// enough time and text: one edit with everything so far
+	w.minInterval = 0
+	_, _ = w.Write([]byte(" wor"))
+	assert.Equal(t, []string{"Hello wor"}, edits)
+
+	// below the minimum delta: no edit
+	_, _ = w.Write([]byte("ld"))
+	assert.Len(t, edits, 1)
+
+	// the final flush always happens and can decorate the post
+	appErr = w.Finish(w.Text(), func(post *model.Post) { post.Message += "!" })
+	require.Nil(t, appErr)
+	assert.Equal(t, []string{"Hello wor", "Hello world!"}, edits)
+	assert.Equal(t, "reply", w.Post().Id)
+}
+
+func TestResumePostWriterDiscardRestores(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+
+	var edits []string
+	api.On("UpdatePost", mock.AnythingOfType("*model.Post")).Return(func(post *model.Post) *model.Post {
+		edits = append(edits, post.Message)
+		return post.Clone()
+	}, nil)
+
+	w, err := resumePostWriter(api, &model.Post{Id: "reply", Message: "old answer"}, "...")
+	require.NoError(t, err)
+
+	// a failed regeneration puts the earlier answer back instead of deleting it
+	appErr := w.Discard()
+	require.Nil(t, appErr)
+	assert.Equal(t, []string{"...", "old answer"}, edits)
+	api.AssertNotCalled(t, "DeletePost", mock.Anything)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// typingInterval is how often the typing indicator is repeated; clients hide
+// it a few seconds after the last event.
+const typingInterval = 4 * time.Second
+
+// Reactions on a question post that show the progress of its answer.
+const (
+	reactionWorking = "hourglass"
+	reactionDone    = "white_check_mark"
+	reactionFailed  = "warning"
+)
+
+// startTyping shows the bot as typing in the channel or thread of target until
+// the returned function is called.
+func (p *Plugin) startTyping(target replyTarget) (stop func()) {
+	if !p.getConfiguration().EnableTypingIndicator {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(typingInterval)
+		defer ticker.Stop()
+		for {
+			if appErr := p.API.PublishUserTyping(p.botUserID, target.ChannelID, target.RootID); appErr != nil {
+				logDebug(p, "cannot publish typing indicator", "error", appErr.Error())
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// markWorking adds the working reaction to a question post and removes the
+// result of an earlier answer to it.
+func (p *Plugin) markWorking(postID string) {
+	if postID == "" || !p.getConfiguration().EnableProgressReactions {
+		return
+	}
+	p.removeReaction(postID, reactionDone)
+	p.removeReaction(postID, reactionFailed)
+	p.addReaction(postID, reactionWorking)
+}
+
+// markDone swaps the working reaction of a question post for the outcome.
+func (p *Plugin) markDone(postID string, err error) {
+	if postID == "" || !p.getConfiguration().EnableProgressReactions {
+		return
+	}
+	p.removeReaction(postID, reactionWorking)
+	if err != nil {
+		p.addReaction(postID, reactionFailed)
+	} else {
+		p.addReaction(postID, reactionDone)
+	}
+}
+
+func (p *Plugin) addReaction(postID, emoji string) {
+	if _, appErr := p.API.AddReaction(&model.Reaction{
+		UserId:    p.botUserID,
+		PostId:    postID,
+		EmojiName: emoji,
+	}); appErr != nil {
+		logDebug(p, "cannot add reaction", "post_id", postID, "emoji", emoji, "error", appErr.Error())
+	}
+}
+
+func (p *Plugin) removeReaction(postID, emoji string) {
+	if appErr := p.API.RemoveReaction(&model.Reaction{
+		UserId:    p.botUserID,
+		PostId:    postID,
+		EmojiName: emoji,
+	}); appErr != nil {
+		logDebug(p, "cannot remove reaction", "post_id", postID, "emoji", emoji, "error", appErr.Error())
+	}
+}
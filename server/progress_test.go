@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestProgressReactions(t *testing.T) {
+	reaction := func(emoji string) interface{} {
+		return mock.MatchedBy(func(r *model.Reaction) bool {
+			return r.PostId == "question" && r.UserId == "bot" && r.EmojiName == emoji
+		})
+	}
+
+	for name, tc := range map[string]struct {
+		err     error
+		outcome string
+	}{
+		"success": {nil, reactionDone},
+		"failure": {errors.New("boom"), reactionFailed},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			defer api.AssertExpectations(t)
+			api.On("RemoveReaction", reaction(reactionDone)).Return(nil).Once()
+			api.On("RemoveReaction", reaction(reactionFailed)).Return(nil).Once()
+			api.On("AddReaction", reaction(reactionWorking)).Return(&model.Reaction{}, nil).Once()
+			api.On("RemoveReaction", reaction(reactionWorking)).Return(nil).Once()
+			api.On("AddReaction", reaction(tc.outcome)).Return(&model.Reaction{}, nil).Once()
+
+			p := &Plugin{botUserID: "bot"}
+			p.SetAPI(api)
+			p.setConfiguration(&Configuration{EnableProgressReactions: true})
+
+			p.markWorking("question")
+			p.markDone("question", tc.err)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+
+		p := &Plugin{botUserID: "bot"}
+		p.SetAPI(api)
+		p.setConfiguration(&Configuration{})
+
+		p.markWorking("question")
+		p.markDone("question", nil)
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// Rate limit windows. Windows are fixed and aligned to UTC, so a daily quota
+// resets at midnight UTC like the usage roll-ups.
+const (
+	rateWindowMinute = time.Minute
+	rateWindowDay    = 24 * time.Hour
+)
+
+// errRateLimited is the outcome of a question refused by a rate limit.
+var errRateLimited = errors.New("rate limit exceeded")
+
+// rateLimit caps the questions of one user or channel per window.
+type rateLimit struct {
+	scope  string // "user" or "channel"
+	id     string
+	window time.Duration
+	limit  int
+}
+
+// rateLimitHit describes the limit a question ran into.
+type rateLimitHit struct {
+	rateLimit
+	resetAt time.Time
+}
+
+// rateLimits returns the configured limits for a question of userID in
+// channelID, shortest window first. Limits of zero or less are disabled.
+func (c *Configuration) rateLimits(userID, channelID string) []rateLimit {
+	var limits []rateLimit
+	for _, l := range []rateLimit{
+		{"user", userID, rateWindowMinute, c.UserRequestsPerMinute},
+		{"channel", channelID, rateWindowMinute, c.ChannelRequestsPerMinute},
+		{"user", userID, rateWindowDay, c.UserRequestsPerDay},
+		{"channel", channelID, rateWindowDay, c.ChannelRequestsPerDay},
+	} {
+		if l.limit > 0 {
+			limits = append(limits, l)
+		}
+	}
+	return limits
+}
+
+// checkRateLimits counts q against the limits of its user and channel and
+// returns the counters it incremented, or the first limit it would exceed. A
+// refused question is not counted against any limit; a question refused later
+// on is taken back with uncountRequest. Users with an exempt role are not
+// counted.
+func (p *Plugin) checkRateLimits(q *question) ([]rateCounter, *rateLimitHit) {
+	cfg := p.getConfiguration()
+	limits := cfg.rateLimits(q.req.UserID, q.req.ChannelID)
+	if len(limits) == 0 || p.rateLimitExempt(cfg, q) {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	var counted []rateCounter
+	for _, l := range limits {
+		start := now.Truncate(l.window)
+		resetAt := start.Add(l.window)
+		counter := rateCounter{
+			key: fmt.Sprintf("%s-%d-%s-%d", l.scope, int(l.window.Seconds()), l.id, start.Unix()),
+			ttl: resetAt.Sub(now) + time.Minute,
+		}
+		_, ok, err := p.kvstore.IncrementRateCounter(counter.key, l.limit, counter.ttl)
+		switch {
+		case errors.Is(err, kvstore.ErrContention):
+			// a burst that busy is refused rather than let through uncounted
+			logError(p, err, "cannot count request", "scope", l.scope, "id", l.id)
+		case err != nil:
+			// a KV store problem should not lock everybody out
+			logError(p, err, "cannot count request", "scope", l.scope, "id", l.id)
+			continue
+		}
+		if !ok {
+			p.uncountRequest(counted)
+			return nil, &rateLimitHit{rateLimit: l, resetAt: resetAt}
+		}
+		counted = append(counted, counter)
+	}
+	return counted, nil
+}
+
+// rateCounter is the KV store counter of one rate limit window.
+type rateCounter struct {
+	key string
+	ttl time.Duration
+}
+
+// uncountRequest takes a refused question back from counted, the counters it
+// was already counted on.
+func (p *Plugin) uncountRequest(counted []rateCounter) {
+	for _, c := range counted {
+		if err := p.kvstore.DecrementRateCounter(c.key, c.ttl); err != nil {
+			logError(p, err, "cannot uncount request", "key", c.key)
+		}
+	}
+}
+
+// rateLimitExempt reports whether the asking user has a role exempt from
+// rate limits.
+func (p *Plugin) rateLimitExempt(cfg *Configuration, q *question) bool {
+	if len(cfg.RateLimitExemptRoleNames) == 0 {
+		return false
+	}
+	roles, appErr := p.userRoles(q.req.TeamID, q.req.ChannelID, q.req.UserID)
+	if appErr != nil {
+		logError(p, appErr, "cannot resolve user roles", "user_id", q.req.UserID)
+		return false
+	}
+	for _, role := range roles {
+		if containsFold(cfg.RateLimitExemptRoleNames, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// message returns the localized notice for the user who hit the limit.
+func (h *rateLimitHit) message(locale string) string {
+	id, period := msgUserRateLimited, msgPerMinute
+	if h.scope == "channel" {
+		id = msgChannelRateLimited
+	}
+	if h.window == rateWindowDay {
+		period = msgPerDay
+	}
+
+	reset := h.resetAt.Format("15:04 UTC")
+	if h.resetAt.Sub(time.Now()) > time.Hour {
+		reset = h.resetAt.Format("2006-01-02 15:04 UTC")
+	}
+	return fmt.Sprintf(localize(locale, id), h.limit, localize(locale, period), reset)
+}
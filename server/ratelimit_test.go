@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// counterStore counts in memory; other KVStore methods are not used.
+type counterStore struct {
+	kvstore.KVStore
+
+	mu        sync.Mutex
+	counts    map[string]int
+	contended bool // every update fails with kvstore.ErrContention
+}
+
+func (s *counterStore) IncrementRateCounter(key string, limit int, _ time.Duration) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.contended {
+		return 0, false, kvstore.ErrContention
+	}
+	if s.counts[key] >= limit {
+		return s.counts[key], false, nil
+	}
+	s.counts[key]++
+	return s.counts[key], true, nil
+}
+
+func (s *counterStore) DecrementRateCounter(key string, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[key]--
+	return nil
+}
+
+func TestCheckRateLimits(t *testing.T) {
+	p := &Plugin{kvstore: &counterStore{counts: map[string]int{}}}
+	p.setConfiguration(&Configuration{UserRequestsPerMinute: 2, ChannelRequestsPerDay: 3})
+
+	ask := func(userID string) *rateLimitHit {
+		_, hit := p.checkRateLimits(&question{req: &AskRequest{UserID: userID, ChannelID: "channel"}})
+		return hit
+	}
+
+	assert.Nil(t, ask("alice"))
+	assert.Nil(t, ask("alice"))
+
+	hit := ask("alice")
+	require.NotNil(t, hit)
+	assert.Equal(t, "user", hit.scope)
+	assert.Equal(t, rateWindowMinute, hit.window)
+	assert.True(t, hit.resetAt.After(time.Now()))
+	assert.Contains(t, hit.message("en"), "limit of 2 questions per minute")
+
+	// another user still has minute budget, but the channel's daily quota is used up
+	assert.Nil(t, ask("bob"))
+	hit = ask("bob")
+	require.NotNil(t, hit)
+	assert.Equal(t, "channel", hit.scope)
+	assert.Equal(t, rateWindowDay, hit.window)
+	assert.Equal(t, 0, hit.resetAt.Hour())
+
+	// the refused question did not use up bob's minute budget
+	_, hit = p.checkRateLimits(&question{req: &AskRequest{UserID: "bob", ChannelID: "other"}})
+	assert.Nil(t, hit)
+}
+
+func TestCheckRateLimitsFailsClosedOnContention(t *testing.T) {
+	store := &counterStore{counts: map[string]int{}, contended: true}
+	p := &Plugin{kvstore: store}
+	p.setConfiguration(&Configuration{UserRequestsPerMinute: 5})
+
+	counted, hit := p.checkRateLimits(&question{req: &AskRequest{UserID: "alice", ChannelID: "channel"}})
+	require.NotNil(t, hit)
+	assert.Equal(t, "user", hit.scope)
+	assert.Empty(t, counted)
+}
+
+func TestQueueFullQuestionIsNotCounted(t *testing.T) {
+	store := &counterStore{counts: map[string]int{}}
+	api := &plugintest.API{}
+	api.On("SendEphemeralPost", "alice", mock.AnythingOfType("*model.Post")).Return(&model.Post{})
+	p := &Plugin{kvstore: store}
+	p.SetAPI(api)
+	p.setConfiguration(&Configuration{UserRequestsPerMinute: 1, ChannelRequestsPerDay: 5})
+
+	// without a worker pool every question finds the queue full
+	q := &question{
+		req:    &AskRequest{UserID: "alice", ChannelID: "channel"},
+		target: replyTarget{UserID: "alice", ChannelID: "channel", Locale: "en"},
+	}
+	p.enqueueQuestion(q)
+	api.AssertCalled(t, "SendEphemeralPost", "alice", mock.MatchedBy(func(post *model.Post) bool {
+		return post.Message == localize("en", msgBusy)
+	}))
+	assert.Len(t, store.counts, 2)
+	for key, count := range store.counts {
+		assert.Zero(t, count, key)
+	}
+}
+
+func TestRateLimitsDisabled(t *testing.T) {
+	var cfg Configuration
+	assert.Empty(t, cfg.rateLimits("user", "channel"))
+}
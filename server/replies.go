@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Message IDs of the bot's own user-facing texts.
+const (
+	msgEmptyAnswer     = "empty_answer"
+	msgSources         = "sources"
+	msgTyping          = "typing"
+	msgIncomplete      = "incomplete"
+	msgBusy            = "busy"
+	msgRegenerated     = "regenerated"
+	msgRedacted        = "redacted"
+	msgNotAllowed      = "not_allowed"
+	msgAnswerAttached  = "answer_attached"
+	msgFileUnsupported = "file_unsupported"
+	msgFileTooLarge    = "file_too_large"
+	msgFileTruncated   = "file_truncated"
+
+	msgUserRateLimited    = "user_rate_limited"
+	msgChannelRateLimited = "channel_rate_limited"
+	msgPerMinute          = "per_minute"
+	msgPerDay             = "per_day"
+
+	msgAccessAdminOnly        = "access_admin_only"
+	msgAccessUsage            = "access_usage"
+	msgAccessNotFound         = "access_not_found"
+	msgAccessFailed           = "access_failed"
+	msgAccessAllowed          = "access_allowed"
+	msgAccessBlocked          = "access_blocked"
+	msgAccessRemoved          = "access_removed"
+	msgAccessAllowIneffective = "access_allow_ineffective"
+	msgAccessModes            = "access_modes"
+	msgAccessEmpty            = "access_empty"
+	msgAccessTable            = "access_table"
+	msgAccessTeams            = "access_teams"
+	msgAccessChannels         = "access_channels"
+	msgAccessUsers            = "access_users"
+)
+
+// replyTexts holds the user-facing texts per language. Error kinds double as
+// message IDs for the failure replies.
+var replyTexts = map[string]map[string]string{
+	"fa": {
+		msgEmptyAnswer:               "متأسفم، پاسخی دریافت نشد.",
+		msgSources:                   "منابع",
+		msgTyping:                    "در حال تایپ...",
+		msgIncomplete:                "پاسخ ناقص ماند.",
+		msgBusy:                      "در حال حاضر سؤال‌های زیادی در صف هستند. لطفاً چند لحظهٔ دیگر دوباره بپرسید.",
+		msgRegenerated:               "_(ویرایش‌شده: پاسخ پس از ویرایش سؤال دوباره تولید شد)_",
+		msgRedacted:                  "_این پاسخ حذف شد زیرا پیام سؤال حذف شده است._",
+		msgNotAllowed:                "دستیار در این کانال یا برای شما فعال نیست.",
+		msgAnswerAttached:            "پاسخ کامل طولانی است و به‌صورت فایل پیوست شده است.",
+		msgFileUnsupported:           "نوع این فایل‌ها پشتیبانی نمی‌شود و به دستیار ارسال نشدند: %s (فقط فایل‌های متنی مانند txt، md، log، csv، json و کد منبع خوانده می‌شوند)",
+		msgFileTooLarge:              "این فایل‌ها از حد مجاز حجم بزرگ‌تر بودند و به دستیار ارسال نشدند: %s",
+		msgFileTruncated:             "فقط ابتدای این فایل‌ها به دستیار ارسال شد: %s",
+		msgUserRateLimited:           "به سقف %d سؤال در %s رسیده‌اید. پس از %s دوباره می‌توانید بپرسید.",
+		msgChannelRateLimited:        "این کانال به سقف %d سؤال در %s رسیده است. پس از %s دوباره می‌توانید بپرسید.",
+		msgPerMinute:                 "دقیقه",
+		msgPerDay:                    "روز",
+		msgAccessAdminOnly:           "فقط مدیران سیستم می‌توانند دسترسی دستیار را تغییر دهند.",
+		msgAccessUsage:               "استفاده: `/mu access allow|block|remove <تیم> <~کانال> <@کاربر>...` یا `/mu access list`",
+		msgAccessNotFound:            "«%s» پیدا نشد. کانال‌ها را با ~ و کاربران را با @ بنویسید؛ نام بدون پیشوند نام تیم است.",
+		msgAccessFailed:              "ذخیرهٔ قواعد دسترسی ممکن نشد. لطفاً دوباره تلاش کنید.",
+		msgAccessAllowed:             "مجاز شد: %s",
+		msgAccessBlocked:             "مسدود شد: %s",
+		msgAccessRemoved:             "از قواعد حذف شد: %s",
+		msgAccessAllowIneffective:    "%s مجاز نشد: حالت دسترسی آن `%s` است و مجازکردن با `/mu access` فقط در حالت `allow_selected` اثر دارد. ابتدا حالت را در کنسول سیستم تغییر دهید.",
+		msgAccessModes:               "حالت‌های دسترسی (کنسول سیستم): تیم `%s`، کانال `%s`، کاربر `%s`. موارد مسدود زیر در همهٔ حالت‌ها اثر دارند و موارد مجاز در حالت `allow_selected` به فهرست کنسول افزوده می‌شوند.",
+		msgAccessEmpty:               "هنوز قاعده‌ای با `/mu access` ثبت نشده است.",
+		msgAccessTable:               "| | مجاز | مسدود |\n|---|---|---|",
+		msgAccessTeams:               "تیم‌ها",
+		msgAccessChannels:            "کانال‌ها",
+		msgAccessUsers:               "کاربران",
+		string(ErrKindUnauthorized):  "دستیار نتوانست به سرویس هوش مصنوعی وارد شود. لطفاً به مدیر سیستم اطلاع دهید تا کلید API و شناسهٔ عامل را بررسی کند.",
+		string(ErrKindQuotaExceeded): "سهمیهٔ استفاده از سرویس هوش مصنوعی فعلاً تمام شده است. لطفاً کمی بعد دوباره تلاش کنید.",
+		string(ErrKindTimeout):       "پاسخ دستیار بیش از حد طول کشید. لطفاً دوباره تلاش کنید یا سؤال کوتاه‌تری بپرسید.",
+		string(ErrKindUpstream):      "سرویس هوش مصنوعی با خطا مواجه شد. لطفاً چند لحظهٔ دیگر دوباره تلاش کنید.",
+		string(ErrKindMalformed):     "پاسخ نامعتبری از سرویس هوش مصنوعی دریافت شد. لطفاً دوباره تلاش کنید و اگر تکرار شد به مدیر سیستم اطلاع دهید.",
+		string(ErrKindCanceled):      "درخواست شما لغو شد. لطفاً دوباره تلاش کنید.",
+	},
+	"en": {
+		msgEmptyAnswer:               "Sorry, no answer was received.",
+		msgSources:                   "Sources",
+		msgTyping:                    "Typing...",
+		msgIncomplete:                "The answer is incomplete.",
+		msgBusy:                      "The assistant is busy with many questions right now. Please try again in a moment.",
+		msgRegenerated:               "_(edited: answer regenerated after the question was edited)_",
+		msgRedacted:                  "_This answer was removed because the question was deleted._",
+		msgNotAllowed:                "The assistant is not available in this channel or for your account.",
+		msgAnswerAttached:            "The full answer is long and attached as a file.",
+		msgFileUnsupported:           "These files have an unsupported type and were not sent to the assistant: %s (only text files such as txt, md, log, csv, json and source code are read)",
+		msgFileTooLarge:              "These files exceed the size limit and were not sent to the assistant: %s",
+		msgFileTruncated:             "Only the beginning of these files was sent to the assistant: %s",
+		msgUserRateLimited:           "You have reached your limit of %d questions per %s. You can ask again after %s.",
+		msgChannelRateLimited:        "This channel has reached its limit of %d questions per %s. You can ask again after %s.",
+		msgPerMinute:                 "minute",
+		msgPerDay:                    "day",
+		msgAccessAdminOnly:           "Only system admins can change who can use the assistant.",
+		msgAccessUsage:               "Usage: `/mu access allow|block|remove <team> <~channel> <@user>...` or `/mu access list`",
+		msgAccessNotFound:            "Could not find \"%s\". Prefix channels with ~ and users with @; a name without prefix is a team name.",
+		msgAccessFailed:              "The access rules could not be saved. Please try again.",
+		msgAccessAllowed:             "Allowed: %s",
+		msgAccessBlocked:             "Blocked: %s",
+		msgAccessRemoved:             "Removed from the rules: %s",
+		msgAccessAllowIneffective:    "%s was not allowed: its access mode is `%s`, and allows made with `/mu access` only apply in `allow_selected` mode. Change the mode in the System Console first.",
+		msgAccessModes:               "Access modes (System Console): teams `%s`, channels `%s`, users `%s`. The blocks below apply in every mode; the allows are added to the console allow lists in `allow_selected` mode.",
+		msgAccessEmpty:               "No rules have been added with `/mu access` yet.",
+		msgAccessTable:               "| | Allowed | Blocked |\n|---|---|---|",
+		msgAccessTeams:               "Teams",
+		msgAccessChannels:            "Channels",
+		msgAccessUsers:               "Users",
+		string(ErrKindUnauthorized):  "The assistant could not sign in to its AI service. Please ask a system admin to check the API key and agent ID.",
+		string(ErrKindQuotaExceeded): "The AI service usage quota is currently exhausted. Please try again later.",
+		string(ErrKindTimeout):       "The assistant took too long to answer. Please try again or ask a shorter question.",
+		string(ErrKindUpstream):      "The AI service returned an error. Please try again in a moment.",
+		string(ErrKindMalformed):     "The AI service sent a response the assistant could not understand. Please try again and tell a system admin if it keeps happening.",
+		string(ErrKindCanceled):      "Your request was canceled. Please try again.",
+	},
+}
+
+// localize returns the text for id in the user's language, falling back to English.
+func localize(locale, id string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(locale), "-")
+	if text, ok := replyTexts[lang][id]; ok {
+		return text
+	}
+	return replyTexts["en"][id]
+}
+
+// userLocale returns the interface language of a user, or "" if unknown.
+func (p *Plugin) userLocale(userID string) string {
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return ""
+	}
+	return user.Locale
+}
+
+// failureMessage returns the localized, actionable text for a backend error.
+func (p *Plugin) failureMessage(locale string, err error) string {
+	kind := errorKind(err)
+	if kind == ErrKindUnavailable {
+		return p.getConfiguration().unavailableMessage()
+	}
+	return localize(locale, string(kind))
+}
+
+// replyRootID returns the thread a reply to post belongs in.
+func replyRootID(post *model.Post) string {
+	if post.RootId != "" {
+		return post.RootId
+	}
+	return post.Id
+}
+
+// notifyFailure tells the asking user, in an ephemeral message, why the bot
+// could not answer.
+func (p *Plugin) notifyFailure(target replyTarget, err error) {
+	p.sendEphemeral(target, p.failureMessage(target.Locale, err))
+}
+
+// sendEphemeral shows message to the asking user only, next to the reply.
+func (p *Plugin) sendEphemeral(target replyTarget, message string) {
+	p.API.SendEphemeralPost(target.UserID, &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: target.ChannelID,
+		RootId:    target.RootID,
+		Message:   message,
+	})
+}
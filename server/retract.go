@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// Values of the "DeletedQuestionReplies" setting.
+const (
+	retractDelete = "delete"
+	retractRedact = "redact"
+)
+
+// retractMode returns what happens to the replies of a deleted question.
+func (c *Configuration) retractMode() string {
+	if c.DeletedQuestionReplies == retractRedact {
+		return retractRedact
+	}
+	return retractDelete
+}
+
+// retractReplies deletes or redacts the bot replies to a deleted question post
+// and forgets the link between them. A redaction notice is written in locale.
+func (p *Plugin) retractReplies(questionPostID, locale string) {
+	reply, err := p.kvstore.GetReply(questionPostID)
+	if err != nil {
+		logError(p, err, "cannot get reply", "post_id", questionPostID)
+		return
+	}
+	if reply == nil {
+		return
+	}
+
+	mode := p.getConfiguration().retractMode()
+	for _, id := range reply.ReplyPostIDs {
+		if appErr := p.retractReply(id, mode, locale); appErr != nil {
+			logError(p, appErr, "cannot retract reply", "post_id", id, "mode", mode)
+			// keep the link so that a later deletion can retry
+			return
+		}
+	}
+
+	if err := p.kvstore.DeleteReply(questionPostID); err != nil {
+		logError(p, err, "cannot delete reply", "post_id", questionPostID)
+	}
+}
+
+// retractReply deletes a single reply post or replaces its content with a
+// redaction notice. Replies that are already gone, for example because the
+// whole thread was deleted, are skipped.
+func (p *Plugin) retractReply(postID, mode, locale string) *model.AppError {
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return appErr
+	}
+
+	if mode == retractDelete {
+		return p.API.DeletePost(postID)
+	}
+
+	post = post.Clone()
+	post.Message = localize(locale, msgRedacted)
+	post.SetProps(nil)
+	post.FileIds = nil
+	_, appErr = p.API.UpdatePost(post)
+	return appErr
+}
+
+// questionDeleted reports whether the question post was deleted while the
+// bot was answering it.
+func (p *Plugin) questionDeleted(questionPostID string) bool {
+	post, appErr := p.API.GetPost(questionPostID)
+	if appErr != nil {
+		return appErr.StatusCode == http.StatusNotFound
+	}
+	return post.DeleteAt != 0
+}
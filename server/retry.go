@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = 500 * time.Millisecond
+	defaultRetryMaxDelay    = 10 * time.Second
+)
+
+// retryPolicy describes how transient backend failures are retried.
+type retryPolicy struct {
+	MaxAttempts int // total attempts including the first one
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// newRetryPolicy reads the retry settings, falling back to the defaults for
+// unset values. A negative attempt count disables retries.
+func newRetryPolicy(cfg *Configuration) retryPolicy {
+	policy := retryPolicy{
+		MaxAttempts: cfg.RetryMaxAttempts,
+		BaseDelay:   time.Duration(cfg.RetryBaseDelayMs) * time.Millisecond,
+		MaxDelay:    secondsOr(cfg.RetryMaxDelaySeconds, defaultRetryMaxDelay),
+	}
+	switch {
+	case policy.MaxAttempts == 0:
+		policy.MaxAttempts = defaultRetryMaxAttempts
+	case policy.MaxAttempts < 0:
+		policy.MaxAttempts = 1
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = defaultRetryBaseDelay
+	}
+	return policy
+}
+
+// backoff returns the jittered delay before retry number attempt (starting at 1):
+// a random value between half and all of BaseDelay*2^(attempt-1), capped at MaxDelay.
+func (rp retryPolicy) backoff(attempt int) time.Duration {
+	delay := rp.MaxDelay
+	if shift := attempt - 1; shift < 30 {
+		if d := rp.BaseDelay << shift; d > 0 && d < rp.MaxDelay {
+			delay = d
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+// retryTransport retries requests that failed with a transient error.
+// Requests are only retried when their body can be replayed.
+type retryTransport struct {
+	base   http.RoundTripper
+	policy retryPolicy
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 {
+			attemptReq = req.Clone(ctx)
+			if req.Body != nil && req.Body != http.NoBody {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if attempt >= t.policy.MaxAttempts || !isRetryable(resp, err) || !canReplay(req) {
+			return resp, err
+		}
+
+		delay := t.policy.backoff(attempt)
+		if resp != nil {
+			if after, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				if after > t.policy.MaxDelay {
+					// the backend asks for a longer pause than we are willing to wait
+					return resp, err
+				}
+				delay = after
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			// the next attempt could not finish in time; report this failure
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func canReplay(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// isRetryable reports whether a failed attempt is worth repeating.
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return false
+		}
+		var netErr net.Error
+		return errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, io.ErrUnexpectedEOF) ||
+			errors.Is(err, io.EOF) ||
+			(errors.As(err, &netErr) && netErr.Timeout())
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an HTTP date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRetryServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		status := statuses[len(statuses)-1]
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestRetryTransport(t *testing.T) {
+	policy := retryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	post := func(t *testing.T, server *httptest.Server, ctx context.Context) (*http.Response, error) {
+		client := &http.Client{Transport: &retryTransport{base: http.DefaultTransport, policy: policy}}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader(`{"query":"hi"}`))
+		require.NoError(t, err)
+		return client.Do(req)
+	}
+
+	t.Run("recovers from transient statuses", func(t *testing.T) {
+		server, calls := newRetryServer(t, http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusOK)
+		resp, err := post(t, server, context.Background())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.EqualValues(t, 3, atomic.LoadInt32(calls))
+	})
+
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		server, calls := newRetryServer(t, http.StatusBadGateway)
+		resp, err := post(t, server, context.Background())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.EqualValues(t, 3, atomic.LoadInt32(calls))
+	})
+
+	t.Run("does not retry permanent failures", func(t *testing.T) {
+		server, calls := newRetryServer(t, http.StatusForbidden)
+		resp, err := post(t, server, context.Background())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.EqualValues(t, 1, atomic.LoadInt32(calls))
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	d, ok := retryAfter("7", now)
+	assert.True(t, ok)
+	assert.Equal(t, 7*time.Second, d)
+
+	d, ok = retryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+
+	_, ok = retryAfter("soon", now)
+	assert.False(t, ok)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := retryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 1; attempt <= 8; attempt++ {
+		d := policy.backoff(attempt)
+		assert.LessOrEqual(t, d, time.Second)
+		assert.GreaterOrEqual(t, d, 50*time.Millisecond)
+	}
+}
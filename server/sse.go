@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxSSEEventSize caps a single event so a misbehaving server cannot exhaust memory.
+const maxSSEEventSize = 8 << 20
+
+var (
+	// errStreamDone is returned by an event handler to end the stream cleanly,
+	// e.g. on a "[DONE]" sentinel.
+	errStreamDone = errors.New("stream done")
+
+	errSSEEventTooLarge = fmt.Errorf("server-sent event exceeds %d bytes", maxSSEEventSize)
+)
+
+// sseEvent is a single dispatched Server-Sent Event.
+type sseEvent struct {
+	Event string // "message" when the server did not send an event type
+	Data  string // data lines joined with "\n"
+	ID    string
+	Retry int // reconnection time in milliseconds, 0 if not sent
+}
+
+// sseDecoder reads Server-Sent Events as defined by the WHATWG HTML standard.
+// Unlike bufio.Scanner it has no per-line limit other than maxSSEEventSize.
+type sseDecoder struct {
+	r *bufio.Reader
+}
+
+func newSSEDecoder(r io.Reader) *sseDecoder {
+	return &sseDecoder{r: bufio.NewReader(r)}
+}
+
+// Next returns the next event. It returns io.EOF when the stream ends on an
+// event boundary and io.ErrUnexpectedEOF when it ends in the middle of an event.
+func (d *sseDecoder) Next() (*sseEvent, error) {
+	var (
+		ev      sseEvent
+		data    strings.Builder
+		hasData bool
+		pending bool
+		size    int
+	)
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) && pending {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+
+		if line == "" {
+			if !hasData {
+				// an event without data is not dispatched
+				ev, data, pending, size = sseEvent{}, strings.Builder{}, false, 0
+				continue
+			}
+			if ev.Event == "" {
+				ev.Event = "message"
+			}
+			ev.Data = data.String()
+			return &ev, nil
+		}
+
+		size += len(line)
+		if size > maxSSEEventSize {
+			return nil, errSSEEventTooLarge
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // comment, such as a keep-alive
+		}
+		pending = true
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			ev.Event = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				ev.ID = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				ev.Retry = ms
+			}
+		}
+	}
+}
+
+// readLine returns the next line without its CRLF, LF or CR terminator.
+func (d *sseDecoder) readLine() (string, error) {
+	var sb strings.Builder
+	for {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) && sb.Len() > 0 {
+				return sb.String(), nil
+			}
+			return "", err
+		}
+		switch b {
+		case '\n':
+			return sb.String(), nil
+		case '\r':
+			if next, err := d.r.Peek(1); err == nil && next[0] == '\n' {
+				_, _ = d.r.Discard(1)
+			}
+			return sb.String(), nil
+		}
+		if sb.Len() >= maxSSEEventSize {
+			return "", errSSEEventTooLarge
+		}
+		sb.WriteByte(b)
+	}
+}
+
+// pumpSSE decodes body and writes the text returned by handle for each event
+// to stream. The stream is closed with the first decoding or handler error,
+// as a *BackendError, so that the reader sees it; errStreamDone and a clean
+// EOF end it without error.
+func pumpSSE(body io.ReadCloser, stream *pipeStream, handle func(ev *sseEvent) (string, error)) {
+	defer body.Close()
+
+	dec := newSSEDecoder(body)
+	for {
+		ev, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			stream.pw.Close()
+			return
+		}
+		if err != nil {
+			stream.pw.CloseWithError(streamError(fmt.Errorf("read event stream: %w", err)))
+			return
+		}
+
+		text, err := handle(ev)
+		if errors.Is(err, errStreamDone) {
+			stream.pw.Close()
+			return
+		}
+		if err != nil {
+			stream.pw.CloseWithError(streamError(err))
+			return
+		}
+		if text == "" {
+			continue
+		}
+		if _, err := stream.pw.Write([]byte(text)); err != nil {
+			return // reader closed the stream
+		}
+	}
+}
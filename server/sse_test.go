@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readEvents(t *testing.T, input string) ([]*sseEvent, error) {
+	t.Helper()
+	dec := newSSEDecoder(strings.NewReader(input))
+	var events []*sseEvent
+	for {
+		ev, err := dec.Next()
+		if err != nil {
+			return events, err
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestSSEDecoder(t *testing.T) {
+	t.Run("event types, multi-line data and comments", func(t *testing.T) {
+		events, err := readEvents(t, ": keep-alive\r\n"+
+			"data: first\r\n"+
+			"data:second\r\n"+
+			"\r\n"+
+			"event: metadata\n"+
+			"id: 42\n"+
+			"retry: 1500\n"+
+			"data: {\"a\":1}\n"+
+			"\n"+
+			"event: empty\n"+
+			"\n")
+		assert.ErrorIs(t, err, io.EOF)
+		require.Len(t, events, 2)
+		assert.Equal(t, &sseEvent{Event: "message", Data: "first\nsecond"}, events[0])
+		assert.Equal(t, &sseEvent{Event: "metadata", Data: `{"a":1}`, ID: "42", Retry: 1500}, events[1])
+	})
+
+	t.Run("tokens larger than the scanner limit", func(t *testing.T) {
+		big := strings.Repeat("x", 256*1024)
+		events, err := readEvents(t, "data: "+big+"\n\n")
+		assert.ErrorIs(t, err, io.EOF)
+		require.Len(t, events, 1)
+		assert.Equal(t, big, events[0].Data)
+	})
+
+	t.Run("keep-alive comment before EOF", func(t *testing.T) {
+		events, err := readEvents(t, "data: complete\n\n: keep-alive\n")
+		assert.ErrorIs(t, err, io.EOF)
+		assert.Len(t, events, 1)
+	})
+
+	t.Run("CR line endings", func(t *testing.T) {
+		events, err := readEvents(t, "event: answer\rdata: a\rdata: b\r\rdata: c\r\n\r\n")
+		assert.ErrorIs(t, err, io.EOF)
+		require.Len(t, events, 2)
+		assert.Equal(t, &sseEvent{Event: "answer", Data: "a\nb"}, events[0])
+		assert.Equal(t, &sseEvent{Event: "message", Data: "c"}, events[1])
+	})
+
+	t.Run("stream ending mid-event", func(t *testing.T) {
+		events, err := readEvents(t, "data: complete\n\ndata: trunc")
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Len(t, events, 1)
+	})
+}
+
+func TestMuChatStream(t *testing.T) {
+	serve := func(body string) *MuChatClient {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, body)
+		}))
+		t.Cleanup(server.Close)
+		return NewMuChatClient(server.URL, "key", "agent", server.Client())
+	}
+
+	t.Run("answer, metadata and done", func(t *testing.T) {
+		client := serve("data: Hel\n\n" +
+			"data: {\"answer\":\"lo\"}\n\n" +
+			"event: endpoint_response\ndata: {\"answer\":\"Hello\",\"conversationId\":\"conv-1\",\"messageId\":\"msg-1\"," +
+			"\"sources\":[{\"datasource_name\":\"Runbook\",\"source_url\":\"https://wiki/runbook\",\"text\":\"restart\"}]}\n\n" +
+			"data: [DONE]\n\n")
+		stream, err := client.Stream(context.Background(), &AskRequest{Query: "hi"})
+		require.NoError(t, err)
+		defer stream.Close()
+
+		text, err := io.ReadAll(stream)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello", string(text))
+		answer := stream.Answer()
+		assert.Equal(t, "conv-1", answer.ConversationID)
+		assert.Equal(t, "msg-1", answer.MessageID)
+		assert.Equal(t, []Source{{Title: "Runbook", URL: "https://wiki/runbook", Snippet: "restart"}}, answer.Sources)
+	})
+
+	t.Run("raw tokens that look like JSON", func(t *testing.T) {
+		client := serve("event: answer\ndata: func main() \n\n" +
+			"event: answer\ndata: {\n\n" +
+			"event: answer\ndata: {}\n\n" +
+			"event: answer\ndata: }\n\n" +
+			"data: [DONE]\n\n")
+		stream, err := client.Stream(context.Background(), &AskRequest{Query: "hi"})
+		require.NoError(t, err)
+		defer stream.Close()
+
+		text, err := io.ReadAll(stream)
+		require.NoError(t, err)
+		assert.Equal(t, "func main() {{}}", string(text))
+	})
+
+	t.Run("error event reaches the reader", func(t *testing.T) {
+		client := serve("data: partial\n\nevent: error\ndata: {\"error\":\"quota exceeded\"}\n\n")
+		stream, err := client.Stream(context.Background(), &AskRequest{Query: "hi"})
+		require.NoError(t, err)
+		defer stream.Close()
+
+		text, err := io.ReadAll(stream)
+		assert.Equal(t, "partial", string(text))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "quota exceeded")
+	})
+}
@@ -0,0 +1,94 @@
+package kvstore
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const accessRulesKey = "access-rules"
+
+// AccessList holds the IDs explicitly allowed or blocked.
+type AccessList struct {
+	Allow []string `json:"allow,omitempty"`
+	Block []string `json:"block,omitempty"`
+}
+
+// AccessRules are the access lists managed with the /mu access command. They
+// are merged with the lists configured in the System Console.
+type AccessRules struct {
+	Teams    AccessList `json:"teams"`
+	Channels AccessList `json:"channels"`
+	Users    AccessList `json:"users"`
+}
+
+// GetAccessRules returns the stored access rules, empty if there are none.
+func (kv Client) GetAccessRules() (*AccessRules, error) {
+	rules := &AccessRules{}
+	if err := kv.client.KV.Get(accessRulesKey, rules); err != nil {
+		return nil, errors.Wrap(err, "failed to get access rules")
+	}
+	return rules, nil
+}
+
+// UpdateAccessRules atomically applies update to the stored access rules and
+// returns the result.
+func (kv Client) UpdateAccessRules(update func(*AccessRules)) (*AccessRules, error) {
+	var updated *AccessRules
+	err := kv.client.KV.SetAtomicWithRetries(accessRulesKey, func(oldValue []byte) (interface{}, error) {
+		rules := &AccessRules{}
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, rules); err != nil {
+				return nil, err
+			}
+		}
+		update(rules)
+		updated = rules
+		return rules, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to update access rules")
+	}
+	return updated, nil
+}
+
+// Set puts id on the allow or block list, taking it off the other one.
+func (l *AccessList) Set(id string, allow bool) {
+	l.Remove(id)
+	if allow {
+		l.Allow = append(l.Allow, id)
+	} else {
+		l.Block = append(l.Block, id)
+	}
+}
+
+// Remove takes id off both lists. It reports whether id was on one of them.
+func (l *AccessList) Remove(id string) bool {
+	var allowed, blocked bool
+	l.Allow, allowed = without(l.Allow, id)
+	l.Block, blocked = without(l.Block, id)
+	return allowed || blocked
+}
+
+func without(list []string, id string) ([]string, bool) {
+	out := list[:0]
+	found := false
+	for _, item := range list {
+		if item == id {
+			found = true
+			continue
+		}
+		out = append(out, item)
+	}
+	return out, found
+}
+
+// Empty reports whether there are no rules at all.
+func (r *AccessRules) Empty() bool {
+	for _, l := range []AccessList{r.Teams, r.Channels, r.Users} {
+		if len(l.Allow)+len(l.Block) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package kvstore
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+)
+
+const (
+	conversationKeyPrefix = "conversation-"
+
+	// conversationTTL bounds how long an idle thread keeps its backend
+	// conversation. Every answer stores the ID again, restarting the TTL.
+	conversationTTL = 30 * 24 * time.Hour
+)
+
+// GetConversationID returns the backend conversation ID stored for a thread or
+// DM channel, or an empty string if there is none.
+func (kv Client) GetConversationID(key string) (string, error) {
+	var conversationID string
+	if err := kv.client.KV.Get(conversationKeyPrefix+key, &conversationID); err != nil {
+		return "", errors.Wrap(err, "failed to get conversation ID")
+	}
+	return conversationID, nil
+}
+
+// SetConversationID stores the backend conversation ID for a thread or DM
+// channel and restarts its expiry.
+func (kv Client) SetConversationID(key, conversationID string) error {
+	if _, err := kv.client.KV.Set(conversationKeyPrefix+key, conversationID, pluginapi.SetExpiry(conversationTTL)); err != nil {
+		return errors.Wrap(err, "failed to set conversation ID")
+	}
+	return nil
+}
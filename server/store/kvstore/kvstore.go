@@ -1,6 +1,38 @@
 package kvstore
 
+import "time"
+
 type KVStore interface {
 	// Define your methods here. This package is used to access the KVStore pluginapi methods.
 	GetTemplateData(userID string) (string, error)
+
+	// Conversation IDs keyed by thread root ID or DM channel ID.
+	GetConversationID(key string) (string, error)
+	SetConversationID(key, conversationID string) error
+
+	// Daily usage roll-ups per user, channel, team and agent.
+	RecordUsage(day string, rec UsageRecord) error
+	GetDailyUsage(day string) (*DailyUsage, error)
+
+	// Bot replies keyed by the question post they answer.
+	GetReply(questionPostID string) (*Reply, error)
+	SetReply(questionPostID string, reply *Reply) error
+	DeleteReply(questionPostID string) error
+	ClaimRegeneration(questionPostID string, cooldown time.Duration) (bool, error)
+	SetRegenerationPending(questionPostID string, ttl time.Duration) error
+	TakeRegenerationPending(questionPostID string) (bool, error)
+
+	// Cluster-wide claims and outcomes of the posts the bot answers.
+	ClaimPost(postID string) (bool, error)
+	TakeOverPost(postID string, stale *PostState) (bool, error)
+	SetPostOutcome(postID, status string) error
+	GetPostState(postID string) (*PostState, error)
+
+	// Cluster-wide request counters for rate limits and quotas.
+	IncrementRateCounter(key string, limit int, ttl time.Duration) (int, bool, error)
+	DecrementRateCounter(key string, ttl time.Duration) error
+
+	// Access lists managed with the /mu access command.
+	GetAccessRules() (*AccessRules, error)
+	UpdateAccessRules(update func(*AccessRules)) (*AccessRules, error)
 }
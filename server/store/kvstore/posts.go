@@ -0,0 +1,75 @@
+package kvstore
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+)
+
+const (
+	postKeyPrefix = "post-"
+
+	// postStateTTL is how long the claim and outcome of a post are
+	// remembered.
+	postStateTTL = 7 * 24 * time.Hour
+)
+
+// Statuses of a post the bot handles.
+const (
+	PostProcessing = "processing" // claimed, no outcome yet
+	PostAnswered   = "answered"   // the reply was delivered
+	PostFailed     = "failed"     // the bot gave up; the user was told why
+)
+
+// PostState records which stage the handling of a post reached.
+type PostState struct {
+	Status    string `json:"status"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// ClaimPost atomically reserves the handling of a post. It returns false if
+// the post was already claimed, on this or another node, so that every post
+// is answered at most once.
+func (kv Client) ClaimPost(postID string) (bool, error) {
+	state := &PostState{Status: PostProcessing, UpdatedAt: model.GetMillis()}
+	claimed, err := kv.client.KV.Set(postKeyPrefix+postID, state,
+		pluginapi.SetAtomic(nil), pluginapi.SetExpiry(postStateTTL))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to claim post")
+	}
+	return claimed, nil
+}
+
+// TakeOverPost atomically replaces an abandoned claim with a new one. It
+// returns false if the state of the post is no longer stale, for example
+// because another node took it over first or recorded an outcome.
+func (kv Client) TakeOverPost(postID string, stale *PostState) (bool, error) {
+	state := &PostState{Status: PostProcessing, UpdatedAt: model.GetMillis()}
+	taken, err := kv.client.KV.Set(postKeyPrefix+postID, state,
+		pluginapi.SetAtomic(stale), pluginapi.SetExpiry(postStateTTL))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to take over post")
+	}
+	return taken, nil
+}
+
+// SetPostOutcome records the final status of a claimed post.
+func (kv Client) SetPostOutcome(postID, status string) error {
+	state := &PostState{Status: status, UpdatedAt: model.GetMillis()}
+	if _, err := kv.client.KV.Set(postKeyPrefix+postID, state, pluginapi.SetExpiry(postStateTTL)); err != nil {
+		return errors.Wrap(err, "failed to set post outcome")
+	}
+	return nil
+}
+
+// GetPostState returns how far the handling of a post got, or nil if it was
+// never claimed or the record expired.
+func (kv Client) GetPostState(postID string) (*PostState, error) {
+	var state *PostState
+	if err := kv.client.KV.Get(postKeyPrefix+postID, &state); err != nil {
+		return nil, errors.Wrap(err, "failed to get post state")
+	}
+	return state, nil
+}
@@ -0,0 +1,89 @@
+package kvstore
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+)
+
+const (
+	rateCounterKeyPrefix = "ratelimit-"
+
+	// rateCounterAttempts bounds the compare-and-set retries of one update.
+	rateCounterAttempts = 10
+	// rateCounterBackoff is the base delay between two retries; it grows with
+	// each attempt and is jittered so that contending nodes spread out.
+	rateCounterBackoff = 5 * time.Millisecond
+)
+
+// ErrContention is returned when a counter could not be updated because other
+// updates kept changing it.
+var ErrContention = errors.New("too many concurrent updates")
+
+// IncrementRateCounter atomically adds one to the counter key, which is shared
+// by all nodes of a cluster, unless the count would exceed limit. It returns
+// the count and whether it was incremented. The counter is removed ttl after
+// its last update.
+func (kv Client) IncrementRateCounter(key string, limit int, ttl time.Duration) (int, bool, error) {
+	count, ok := 0, false
+	err := kv.updateRateCounter(key, ttl, func(current int) (int, bool) {
+		if current >= limit {
+			count, ok = current, false
+			return current, false
+		}
+		count, ok = current+1, true
+		return count, true
+	})
+	if err != nil {
+		return 0, false, errors.Wrap(err, "failed to increment rate counter")
+	}
+	return count, ok, nil
+}
+
+// DecrementRateCounter atomically takes back one increment of the counter key,
+// for a request that was refused by a later limit.
+func (kv Client) DecrementRateCounter(key string, ttl time.Duration) error {
+	err := kv.updateRateCounter(key, ttl, func(current int) (int, bool) {
+		return current - 1, current > 0
+	})
+	return errors.Wrap(err, "failed to decrement rate counter")
+}
+
+// updateRateCounter applies update to the counter key with compare-and-set,
+// retrying with backoff when another node changed it in between. update
+// returns the new count and whether to store it.
+func (kv Client) updateRateCounter(key string, ttl time.Duration, update func(current int) (int, bool)) error {
+	key = rateCounterKeyPrefix + key
+	for attempt := 0; attempt < rateCounterAttempts; attempt++ {
+		if attempt > 0 {
+			backoff := rateCounterBackoff * time.Duration(attempt)
+			time.Sleep(backoff/2 + rand.N(backoff/2+1))
+		}
+
+		var current *int
+		if err := kv.client.KV.Get(key, &current); err != nil {
+			return errors.Wrap(err, "failed to get rate counter")
+		}
+
+		count := 0
+		atomic := pluginapi.SetAtomic(nil)
+		if current != nil {
+			count = *current
+			atomic = pluginapi.SetAtomic(*current)
+		}
+		next, ok := update(count)
+		if !ok {
+			return nil
+		}
+		stored, err := kv.client.KV.Set(key, next, atomic, pluginapi.SetExpiry(ttl))
+		if err != nil {
+			return errors.Wrap(err, "failed to set rate counter")
+		}
+		if stored {
+			return nil
+		}
+	}
+	return ErrContention
+}
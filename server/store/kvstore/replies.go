@@ -0,0 +1,76 @@
+package kvstore
+
+import (
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/pkg/errors"
+)
+
+const (
+	replyKeyPrefix      = "reply-"
+	regenerateKeyPrefix = "regenerate-"
+	pendingKeyPrefix    = "regenerate-pending-"
+)
+
+// Reply links a question post to the bot posts that answer it.
+type Reply struct {
+	ReplyPostIDs []string `json:"reply_post_ids"`
+}
+
+// GetReply returns the bot reply of a question post, or nil if there is none.
+func (kv Client) GetReply(questionPostID string) (*Reply, error) {
+	var reply *Reply
+	if err := kv.client.KV.Get(replyKeyPrefix+questionPostID, &reply); err != nil {
+		return nil, errors.Wrap(err, "failed to get reply")
+	}
+	return reply, nil
+}
+
+// SetReply stores the bot reply of a question post.
+func (kv Client) SetReply(questionPostID string, reply *Reply) error {
+	if _, err := kv.client.KV.Set(replyKeyPrefix+questionPostID, reply); err != nil {
+		return errors.Wrap(err, "failed to set reply")
+	}
+	return nil
+}
+
+// DeleteReply removes the reply link of a question post.
+func (kv Client) DeleteReply(questionPostID string) error {
+	if err := kv.client.KV.Delete(replyKeyPrefix + questionPostID); err != nil {
+		return errors.Wrap(err, "failed to delete reply")
+	}
+	return nil
+}
+
+// ClaimRegeneration atomically reserves the regeneration of a question's reply
+// for cooldown. It returns false if another regeneration was claimed within
+// the cooldown, on this or another node.
+func (kv Client) ClaimRegeneration(questionPostID string, cooldown time.Duration) (bool, error) {
+	claimed, err := kv.client.KV.Set(regenerateKeyPrefix+questionPostID, true,
+		pluginapi.SetAtomic(nil), pluginapi.SetExpiry(cooldown))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to claim regeneration")
+	}
+	return claimed, nil
+}
+
+// SetRegenerationPending records that a question was edited during the
+// cooldown and its reply still has to be regenerated.
+func (kv Client) SetRegenerationPending(questionPostID string, ttl time.Duration) error {
+	if _, err := kv.client.KV.Set(pendingKeyPrefix+questionPostID, true, pluginapi.SetExpiry(ttl)); err != nil {
+		return errors.Wrap(err, "failed to set pending regeneration")
+	}
+	return nil
+}
+
+// TakeRegenerationPending atomically removes the pending regeneration of a
+// question. It returns false if none was pending, so that only one node
+// regenerates the reply.
+func (kv Client) TakeRegenerationPending(questionPostID string) (bool, error) {
+	taken, err := kv.client.KV.Set(pendingKeyPrefix+questionPostID, nil, pluginapi.SetAtomic(true))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to take pending regeneration")
+	}
+	return taken, nil
+}
@@ -0,0 +1,179 @@
+package kvstore
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const usageKeyPrefix = "usage-"
+
+// Usage roll-up dimensions.
+const (
+	UsageTotal   = "total"
+	UsageUser    = "user"
+	UsageChannel = "channel"
+	UsageTeam    = "team"
+	UsageAgent   = "agent"
+)
+
+// UsageCounters are the accumulated numbers of one roll-up.
+type UsageCounters struct {
+	Requests         int64   `json:"requests"`
+	Failures         int64   `json:"failures"`
+	LatencyMs        int64   `json:"latency_ms"` // sum over all requests
+	InputBytes       int64   `json:"input_bytes"`
+	OutputBytes      int64   `json:"output_bytes"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Credits          float64 `json:"credits"`
+}
+
+// Add accumulates other into c.
+func (c *UsageCounters) Add(other UsageCounters) {
+	c.Requests += other.Requests
+	c.Failures += other.Failures
+	c.LatencyMs += other.LatencyMs
+	c.InputBytes += other.InputBytes
+	c.OutputBytes += other.OutputBytes
+	c.PromptTokens += other.PromptTokens
+	c.CompletionTokens += other.CompletionTokens
+	c.TotalTokens += other.TotalTokens
+	c.Credits += other.Credits
+}
+
+// UsageRecord is a single backend call to be accounted.
+type UsageRecord struct {
+	UserID    string
+	ChannelID string
+	TeamID    string
+	Agent     string
+	Counters  UsageCounters
+}
+
+// DailyUsage is the roll-up of one UTC day.
+type DailyUsage struct {
+	Date     string                    `json:"date"`
+	Total    UsageCounters             `json:"total"`
+	Users    map[string]*UsageCounters `json:"users"`
+	Channels map[string]*UsageCounters `json:"channels"`
+	Teams    map[string]*UsageCounters `json:"teams"`
+	Agents   map[string]*UsageCounters `json:"agents"`
+}
+
+// usageIndex lists the IDs that have a counter for a day, per dimension.
+type usageIndex map[string][]string
+
+func usageCounterKey(day, dimension, id string) string {
+	return usageKeyPrefix + day + "-" + dimension + "-" + id
+}
+
+func usageIndexKey(day string) string {
+	return usageKeyPrefix + day + "-index"
+}
+
+// RecordUsage adds rec to the roll-ups of day (YYYY-MM-DD) for the total and
+// for each non-empty user, channel, team and agent. Counters are updated with
+// compare-and-set so that concurrent calls on several nodes are not lost.
+func (kv Client) RecordUsage(day string, rec UsageRecord) error {
+	targets := []struct{ dimension, id string }{
+		{UsageTotal, UsageTotal},
+		{UsageUser, rec.UserID},
+		{UsageChannel, rec.ChannelID},
+		{UsageTeam, rec.TeamID},
+		{UsageAgent, rec.Agent},
+	}
+	for _, target := range targets {
+		if target.id == "" {
+			continue
+		}
+		key := usageCounterKey(day, target.dimension, target.id)
+		if target.dimension != UsageTotal {
+			// index a counter before creating it, so that a failed index
+			// write is retried with the next record instead of hiding the
+			// counter for good
+			var existing []byte
+			if err := kv.client.KV.Get(key, &existing); err != nil {
+				return errors.Wrap(err, "failed to get usage")
+			}
+			if len(existing) == 0 {
+				if err := kv.indexUsage(day, target.dimension, target.id); err != nil {
+					return err
+				}
+			}
+		}
+		if err := kv.addUsage(key, rec.Counters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addUsage adds counters to key.
+func (kv Client) addUsage(key string, counters UsageCounters) error {
+	err := kv.client.KV.SetAtomicWithRetries(key, func(oldValue []byte) (interface{}, error) {
+		var current UsageCounters
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &current); err != nil {
+				return nil, err
+			}
+		}
+		current.Add(counters)
+		return current, nil
+	})
+	return errors.Wrap(err, "failed to record usage")
+}
+
+func (kv Client) indexUsage(day, dimension, id string) error {
+	err := kv.client.KV.SetAtomicWithRetries(usageIndexKey(day), func(oldValue []byte) (interface{}, error) {
+		index := usageIndex{}
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &index); err != nil {
+				return nil, err
+			}
+		}
+		for _, existing := range index[dimension] {
+			if existing == id {
+				return index, nil
+			}
+		}
+		index[dimension] = append(index[dimension], id)
+		return index, nil
+	})
+	return errors.Wrap(err, "failed to index usage")
+}
+
+// GetDailyUsage returns the roll-ups of day (YYYY-MM-DD).
+func (kv Client) GetDailyUsage(day string) (*DailyUsage, error) {
+	usage := &DailyUsage{
+		Date:     day,
+		Users:    map[string]*UsageCounters{},
+		Channels: map[string]*UsageCounters{},
+		Teams:    map[string]*UsageCounters{},
+		Agents:   map[string]*UsageCounters{},
+	}
+	if err := kv.client.KV.Get(usageCounterKey(day, UsageTotal, UsageTotal), &usage.Total); err != nil {
+		return nil, errors.Wrap(err, "failed to get usage")
+	}
+
+	var index usageIndex
+	if err := kv.client.KV.Get(usageIndexKey(day), &index); err != nil {
+		return nil, errors.Wrap(err, "failed to get usage index")
+	}
+	for dimension, target := range map[string]map[string]*UsageCounters{
+		UsageUser:    usage.Users,
+		UsageChannel: usage.Channels,
+		UsageTeam:    usage.Teams,
+		UsageAgent:   usage.Agents,
+	} {
+		for _, id := range index[dimension] {
+			counters := &UsageCounters{}
+			if err := kv.client.KV.Get(usageCounterKey(day, dimension, id), counters); err != nil {
+				return nil, errors.Wrap(err, "failed to get usage")
+			}
+			target[id] = counters
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,101 @@
+package kvstore
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryKV is an in-memory plugin KV store behind a mocked plugin API.
+type memoryKV struct {
+	mu     sync.Mutex
+	values map[string][]byte
+	fail   map[string]bool // keys whose writes fail
+}
+
+func newMemoryKV(t *testing.T) (Client, *memoryKV) {
+	kv := &memoryKV{values: map[string][]byte{}, fail: map[string]bool{}}
+	api := &plugintest.API{}
+	api.On("KVGet", mock.AnythingOfType("string")).Return(func(key string) []byte {
+		kv.mu.Lock()
+		defer kv.mu.Unlock()
+		return kv.values[key]
+	}, nil)
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(
+		func(key string, value []byte, opts model.PluginKVSetOptions) bool {
+			kv.mu.Lock()
+			defer kv.mu.Unlock()
+			if kv.fail[key] {
+				return false
+			}
+			if opts.Atomic && !bytes.Equal(kv.values[key], opts.OldValue) {
+				return false
+			}
+			if value == nil {
+				delete(kv.values, key)
+			} else {
+				kv.values[key] = value
+			}
+			return true
+		},
+		func(key string, _ []byte, _ model.PluginKVSetOptions) *model.AppError {
+			kv.mu.Lock()
+			defer kv.mu.Unlock()
+			if kv.fail[key] {
+				return model.NewAppError("KVSetWithOptions", "write failed", nil, "", 500)
+			}
+			return nil
+		})
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	return Client{client: pluginapi.NewClient(api, nil)}, kv
+}
+
+func TestRecordUsage(t *testing.T) {
+	client, _ := newMemoryKV(t)
+	rec := UsageRecord{UserID: "alice", ChannelID: "town", TeamID: "team", Agent: "echo",
+		Counters: UsageCounters{Requests: 1, InputBytes: 10, LatencyMs: 100}}
+
+	require.NoError(t, client.RecordUsage("2024-05-01", rec))
+	rec.UserID = "bob"
+	rec.Counters.Failures = 1
+	require.NoError(t, client.RecordUsage("2024-05-01", rec))
+
+	usage, err := client.GetDailyUsage("2024-05-01")
+	require.NoError(t, err)
+	assert.Equal(t, UsageCounters{Requests: 2, Failures: 1, InputBytes: 20, LatencyMs: 200}, usage.Total)
+	assert.Equal(t, UsageCounters{Requests: 1, InputBytes: 10, LatencyMs: 100}, *usage.Users["alice"])
+	assert.Equal(t, int64(1), usage.Users["bob"].Failures)
+	assert.Equal(t, int64(2), usage.Channels["town"].Requests)
+	assert.Equal(t, int64(2), usage.Teams["team"].Requests)
+	assert.Equal(t, int64(2), usage.Agents["echo"].Requests)
+
+	// another day is separate
+	usage, err = client.GetDailyUsage("2024-05-02")
+	require.NoError(t, err)
+	assert.Zero(t, usage.Total)
+	assert.Empty(t, usage.Users)
+}
+
+func TestRecordUsageRetriesFailedIndex(t *testing.T) {
+	client, kv := newMemoryKV(t)
+	rec := UsageRecord{UserID: "alice", Counters: UsageCounters{Requests: 1}}
+
+	kv.fail[usageIndexKey("2024-05-01")] = true
+	require.Error(t, client.RecordUsage("2024-05-01", rec))
+
+	// the next record indexes the counter it missed
+	kv.fail = map[string]bool{}
+	require.NoError(t, client.RecordUsage("2024-05-01", rec))
+	usage, err := client.GetDailyUsage("2024-05-01")
+	require.NoError(t, err)
+	require.Contains(t, usage.Users, "alice")
+	assert.Equal(t, int64(1), usage.Users["alice"].Requests, "the failed record was not counted for the user")
+	assert.Equal(t, int64(2), usage.Total.Requests)
+}
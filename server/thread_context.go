@@ -0,0 +1,130 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const defaultThreadContextMaxChars = 6000
+
+// Roles of the messages in AskRequest.History.
+const (
+	roleUser      = "user"
+	roleAssistant = "assistant"
+)
+
+// ChatMessage is an earlier message of the thread a question was asked in.
+type ChatMessage struct {
+	Role   string // roleUser or roleAssistant
+	Author string // username of the author
+	Text   string
+}
+
+// threadHistory returns the posts of q's thread created before q, oldest
+// first, as chat messages. The most recent messages are kept within the
+// configured budget of characters; if the latest one alone exceeds it, only
+// its end is kept. An earlier answer being regenerated is left out.
+func (p *Plugin) threadHistory(q *question) []ChatMessage {
+	cfg := p.getConfiguration()
+	rootID := q.target.RootID
+	if !cfg.EnableThreadContext || rootID == "" {
+		return nil
+	}
+	budget := cfg.ThreadContextMaxChars
+	if budget <= 0 {
+		budget = defaultThreadContextMaxChars
+	}
+
+	list, appErr := p.API.GetPostThread(rootID)
+	if appErr != nil {
+		logError(p, appErr, "cannot get thread", "root_id", rootID)
+		return nil
+	}
+
+	posts := make([]*model.Post, 0, len(list.Posts))
+	for _, post := range list.Posts {
+		switch {
+		case post.Id == q.postID, q.createAt != 0 && post.CreateAt >= q.createAt:
+		case slices.Contains(q.target.ReplyPostIDs, post.Id):
+		case post.DeleteAt != 0 || post.Type != "" || strings.TrimSpace(post.Message) == "":
+		default:
+			posts = append(posts, post)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool { return posts[i].CreateAt < posts[j].CreateAt })
+
+	usernames := map[string]string{}
+	var history []ChatMessage
+	used := 0
+	for i := len(posts) - 1; i >= 0; i-- {
+		post := posts[i]
+		text := strings.TrimSpace(post.Message)
+		size := utf8.RuneCountInString(text)
+		if used+size > budget {
+			if len(history) > 0 {
+				break
+			}
+			// such as a pasted log right before "explain the error above"
+			text = "…" + text[runeOffset(text, size-budget+1):]
+			size = budget
+		}
+		used += size
+
+		msg := ChatMessage{Role: roleUser, Text: text}
+		if post.UserId == p.botUserID {
+			msg.Role = roleAssistant
+			msg.Author = p.botUsername
+		} else {
+			msg.Author = p.username(post.UserId, usernames)
+		}
+		history = append(history, msg)
+	}
+
+	// restore chronological order
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history
+}
+
+// username returns the username of userID, caching lookups in cache.
+func (p *Plugin) username(userID string, cache map[string]string) string {
+	if name, ok := cache[userID]; ok {
+		return name
+	}
+	name := userID
+	if user, appErr := p.API.GetUser(userID); appErr == nil {
+		name = user.Username
+	}
+	cache[userID] = name
+	return name
+}
+
+// queryWithHistory prepends the thread history to the question and its files
+// for backends that take a single query string.
+func queryWithHistory(req *AskRequest) string {
+	if len(req.History) == 0 {
+		return questionWithFiles(req)
+	}
+	var sb strings.Builder
+	sb.WriteString("Earlier messages in this thread:\n")
+	for _, msg := range req.History {
+		sb.WriteString(formatHistoryLine(msg))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("\nQuestion:\n")
+	sb.WriteString(questionWithFiles(req))
+	return sb.String()
+}
+
+func formatHistoryLine(msg ChatMessage) string {
+	label := "@" + msg.Author
+	if msg.Role == roleAssistant {
+		label = "assistant (@" + msg.Author + ")"
+	}
+	return label + ": " + msg.Text
+}
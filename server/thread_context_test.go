@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThreadHistory(t *testing.T) {
+	setup := func(t *testing.T, budget int, posts ...*model.Post) *Plugin {
+		list := model.NewPostList()
+		for _, post := range posts {
+			list.AddPost(post)
+		}
+		api := &plugintest.API{}
+		api.On("GetPostThread", "root").Return(list, nil)
+		api.On("GetUser", "alice").Return(&model.User{Username: "alice"}, nil)
+
+		p := &Plugin{botUserID: "bot", botUsername: "muchat"}
+		p.SetAPI(api)
+		p.setConfiguration(&Configuration{EnableThreadContext: true, ThreadContextMaxChars: budget})
+		return p
+	}
+	question := &question{
+		postID:   "question",
+		createAt: 300,
+		target:   replyTarget{RootID: "root", ReplyPostIDs: []string{"old-answer"}},
+	}
+
+	t.Run("only posts before the question", func(t *testing.T) {
+		p := setup(t, 1000,
+			&model.Post{Id: "root", UserId: "alice", Message: "deploy failed", CreateAt: 100},
+			&model.Post{Id: "answer", UserId: "bot", Message: "check the logs", CreateAt: 200},
+			&model.Post{Id: "question", UserId: "alice", Message: "which logs?", CreateAt: 300},
+			&model.Post{Id: "old-answer", UserId: "bot", Message: "stale answer", CreateAt: 400},
+			&model.Post{Id: "later", UserId: "alice", Message: "posted while queued", CreateAt: 500},
+		)
+		assert.Equal(t, []ChatMessage{
+			{Role: roleUser, Author: "alice", Text: "deploy failed"},
+			{Role: roleAssistant, Author: "muchat", Text: "check the logs"},
+		}, p.threadHistory(question))
+	})
+
+	t.Run("budget counts characters", func(t *testing.T) {
+		p := setup(t, 10,
+			&model.Post{Id: "root", UserId: "alice", Message: "سلام", CreateAt: 100},
+			&model.Post{Id: "reply", UserId: "alice", Message: "خوبی؟", CreateAt: 200},
+		)
+		assert.Len(t, p.threadHistory(question), 2)
+	})
+
+	t.Run("latest post over budget keeps its end", func(t *testing.T) {
+		log := strings.Repeat("INFO ok\n", 100) + "ERROR disk full"
+		p := setup(t, 24,
+			&model.Post{Id: "root", UserId: "alice", Message: "earlier", CreateAt: 100},
+			&model.Post{Id: "log", UserId: "alice", Message: log, CreateAt: 200},
+		)
+		assert.Equal(t, []ChatMessage{
+			{Role: roleUser, Author: "alice", Text: "…INFO ok\nERROR disk full"},
+		}, p.threadHistory(question))
+	})
+}
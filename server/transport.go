@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultReadTimeout    = 60 * time.Second
+)
+
+// newHTTPClient builds the HTTP client shared by all backends from the proxy,
+// TLS, timeout and retry settings.
+//
+// The connect timeout bounds dialing and the TLS handshake; the read timeout
+// bounds the wait for the response headers. The overall duration of a request
+// is bounded by its context so that long streamed answers are not cut off.
+func newHTTPClient(cfg *Configuration) (*http.Client, error) {
+	connectTimeout := secondsOr(cfg.ConnectTimeoutSeconds, defaultConnectTimeout)
+	readTimeout := secondsOr(cfg.ReadTimeoutSeconds, defaultReadTimeout)
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if proxyURL := strings.TrimSpace(cfg.ProxyURL); proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Host == "" {
+			return nil, errors.Errorf("invalid proxy URL %q", proxyURL)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: readTimeout,
+		ForceAttemptHTTP2:     true,
+		// the client is shared by all requests; keep enough warm connections
+		// to the single backend host to avoid repeated TLS handshakes
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{
+		Transport: &retryTransport{base: transport, policy: newRetryPolicy(cfg)},
+	}, nil
+}
+
+// newTLSConfig returns the TLS configuration with the custom CA bundle and the
+// optional client certificate applied.
+func newTLSConfig(cfg *Configuration) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if ca := strings.TrimSpace(cfg.CACertificate); ca != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM([]byte(ca)) {
+			return nil, errors.New("CA bundle contains no valid PEM certificates")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	certPEM := strings.TrimSpace(cfg.ClientCertificate)
+	keyPEM := strings.TrimSpace(cfg.ClientKey)
+	if certPEM != "" || keyPEM != "" {
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid client certificate or key")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func secondsOr(seconds int, fallback time.Duration) time.Duration {
+	if seconds <= 0 {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
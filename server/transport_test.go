@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustHTTPClient(t *testing.T, cfg *Configuration) *http.Client {
+	client, err := newHTTPClient(cfg)
+	require.NoError(t, err)
+	return client
+}
+
+// newQuietTLSServer starts a TLS test server with tlsConfig that does not log
+// the handshakes the tests expect to fail.
+func newQuietTLSServer(tlsConfig *tls.Config) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.TLS = tlsConfig
+	server.StartTLS()
+	return server
+}
+
+// baseTransport returns the transport below the retries of client.
+func baseTransport(t *testing.T, client *http.Client) *http.Transport {
+	retry, ok := client.Transport.(*retryTransport)
+	require.True(t, ok)
+	transport, ok := retry.base.(*http.Transport)
+	require.True(t, ok)
+	return transport
+}
+
+// certificatePEM encodes the DER certificate cert as PEM.
+func certificatePEM(cert []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}))
+}
+
+// newClientCertificate returns a self-signed client certificate and its key,
+// both as PEM.
+func newClientCertificate(t *testing.T) (certPEM, keyPEM string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mattermost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return certificatePEM(cert), string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+func TestNewHTTPClientTimeouts(t *testing.T) {
+	transport := baseTransport(t, mustHTTPClient(t, &Configuration{}))
+	assert.Equal(t, defaultConnectTimeout, transport.TLSHandshakeTimeout)
+	assert.Equal(t, defaultReadTimeout, transport.ResponseHeaderTimeout)
+
+	transport = baseTransport(t, mustHTTPClient(t, &Configuration{ConnectTimeoutSeconds: 3, ReadTimeoutSeconds: 120}))
+	assert.Equal(t, 3*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 120*time.Second, transport.ResponseHeaderTimeout)
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	transport := baseTransport(t, mustHTTPClient(t, &Configuration{ProxyURL: " http://proxy.internal:3128 "}))
+	req, err := http.NewRequest(http.MethodGet, "https://api.muchat.example/v1", nil)
+	require.NoError(t, err)
+	proxy, err := transport.Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy.internal:3128", proxy.String())
+
+	for _, invalid := range []string{"proxy.internal:3128", "://"} {
+		_, err := newHTTPClient(&Configuration{ProxyURL: invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestNewHTTPClientCACertificate(t *testing.T) {
+	server := newQuietTLSServer(nil)
+	defer server.Close()
+	get := func(cfg *Configuration) error {
+		cfg.RetryMaxAttempts = -1
+		client := mustHTTPClient(t, cfg)
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// the test server's certificate is not trusted by the system pool
+	assert.Error(t, get(&Configuration{}))
+	assert.NoError(t, get(&Configuration{CACertificate: certificatePEM(server.Certificate().Raw)}))
+
+	_, err := newHTTPClient(&Configuration{CACertificate: "not a certificate"})
+	assert.Error(t, err)
+}
+
+func TestNewHTTPClientClientCertificate(t *testing.T) {
+	certPEM, keyPEM := newClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	require.True(t, clientCAs.AppendCertsFromPEM([]byte(certPEM)))
+
+	server := newQuietTLSServer(&tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs})
+	defer server.Close()
+	ca := certificatePEM(server.Certificate().Raw)
+
+	get := func(cfg *Configuration) error {
+		cfg.CACertificate = ca
+		cfg.RetryMaxAttempts = -1
+		client := mustHTTPClient(t, cfg)
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	assert.Error(t, get(&Configuration{}))
+	assert.NoError(t, get(&Configuration{ClientCertificate: certPEM, ClientKey: keyPEM}))
+
+	// a certificate without its key is a configuration error
+	_, err := newHTTPClient(&Configuration{ClientCertificate: certPEM})
+	assert.Error(t, err)
+}
+
+func TestQuestionTimeout(t *testing.T) {
+	// 3 attempts of 10s+60s, 2 retry delays of 10s, 60s to stream
+	assert.Equal(t, 290*time.Second, (&Configuration{}).questionTimeout())
+
+	// a longer read timeout is not cut off
+	cfg := &Configuration{ConnectTimeoutSeconds: 5, ReadTimeoutSeconds: 300, RetryMaxAttempts: -1}
+	assert.Equal(t, 365*time.Second, cfg.questionTimeout())
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+// usageDayFormat is the layout of the day keys of the usage roll-ups (UTC).
+const usageDayFormat = "2006-01-02"
+
+// usageAgent names the agent or model requests are accounted to.
+func usageAgent(cfg *Configuration) string {
+	switch cfg.Backend {
+	case backendOpenAI:
+		return backendOpenAI + ":" + cfg.OpenAIModel
+	case backendEcho:
+		return backendEcho
+	default:
+		return backendMuChat + ":" + cfg.AgentID
+	}
+}
+
+// meteredBackend records every call of a Backend for usage accounting.
+type meteredBackend struct {
+	Backend
+	agent  string
+	record func(kvstore.UsageRecord)
+}
+
+func (b *meteredBackend) newRecord(req *AskRequest) kvstore.UsageRecord {
+	return kvstore.UsageRecord{
+		UserID:    req.UserID,
+		ChannelID: req.ChannelID,
+		TeamID:    req.TeamID,
+		Agent:     b.agent,
+		Counters: kvstore.UsageCounters{
+			Requests: 1,
+			// the question with its files and thread history, as sent
+			InputBytes: int64(len(queryWithHistory(req))),
+		},
+	}
+}
+
+func (b *meteredBackend) Ask(ctx context.Context, req *AskRequest) (*Answer, error) {
+	start := time.Now()
+	answer, err := b.Backend.Ask(ctx, req)
+	if notSent(err) {
+		return answer, err
+	}
+
+	rec := b.newRecord(req)
+	rec.Counters.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		rec.Counters.Failures = 1
+	} else {
+		addAnswerUsage(&rec.Counters, answer)
+		rec.Counters.OutputBytes = int64(len(answer.Text))
+	}
+	b.record(rec)
+	return answer, err
+}
+
+func (b *meteredBackend) Stream(ctx context.Context, req *AskRequest) (AnswerStream, error) {
+	start := time.Now()
+	stream, err := b.Backend.Stream(ctx, req)
+	if notSent(err) {
+		return nil, err
+	}
+	if err != nil {
+		rec := b.newRecord(req)
+		rec.Counters.LatencyMs = time.Since(start).Milliseconds()
+		rec.Counters.Failures = 1
+		b.record(rec)
+		return nil, err
+	}
+	return &meteredStream{AnswerStream: stream, backend: b, rec: b.newRecord(req), start: start}, nil
+}
+
+func addAnswerUsage(c *kvstore.UsageCounters, answer *Answer) {
+	c.PromptTokens = answer.Usage.PromptTokens
+	c.CompletionTokens = answer.Usage.CompletionTokens
+	c.TotalTokens = answer.Usage.TotalTokens
+	c.Credits = answer.Usage.Credits
+}
+
+// meteredStream counts the streamed output and records the call when the
+// stream ends or is closed, whichever comes first.
+type meteredStream struct {
+	AnswerStream
+	backend *meteredBackend
+	rec     kvstore.UsageRecord
+	start   time.Time
+	once    sync.Once
+}
+
+func (s *meteredStream) Read(p []byte) (int, error) {
+	n, err := s.AnswerStream.Read(p)
+	s.rec.Counters.OutputBytes += int64(n)
+	if err != nil {
+		s.finish(err)
+	}
+	return n, err
+}
+
+func (s *meteredStream) Close() error {
+	s.finish(nil)
+	return s.AnswerStream.Close()
+}
+
+func (s *meteredStream) finish(err error) {
+	s.once.Do(func() {
+		s.rec.Counters.LatencyMs = time.Since(s.start).Milliseconds()
+		if err != nil && !errors.Is(err, io.EOF) {
+			s.rec.Counters.Failures = 1
+		}
+		addAnswerUsage(&s.rec.Counters, s.AnswerStream.Answer())
+		s.backend.record(s.rec)
+	})
+}
+
+// usageQueueSize bounds the usage records waiting to be written.
+const usageQueueSize = 1000
+
+// usageEntry is a usage record for the roll-ups of day.
+type usageEntry struct {
+	day string
+	rec kvstore.UsageRecord
+}
+
+// usageRecorder writes usage records to the KV store on a single background
+// goroutine, so that accounting never delays a reply. Records that arrive
+// while its queue is full are dropped. The zero value is stopped.
+type usageRecorder struct {
+	mu    sync.RWMutex
+	queue chan usageEntry
+	done  chan struct{}
+}
+
+// start runs the recorder, writing each record with write.
+func (u *usageRecorder) start(write func(day string, rec kvstore.UsageRecord)) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.queue != nil {
+		return
+	}
+	queue, done := make(chan usageEntry, usageQueueSize), make(chan struct{})
+	u.queue, u.done = queue, done
+	go func() {
+		defer close(done)
+		for entry := range queue {
+			write(entry.day, entry.rec)
+		}
+	}()
+}
+
+// add queues rec for day. It returns false if the recorder is stopped or its
+// queue is full.
+func (u *usageRecorder) add(day string, rec kvstore.UsageRecord) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if u.queue == nil {
+		return false
+	}
+	select {
+	case u.queue <- usageEntry{day: day, rec: rec}:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop refuses new records and waits up to timeout for the queued ones to be
+// written. It reports whether they were written in time.
+func (u *usageRecorder) stop(timeout time.Duration) bool {
+	u.mu.Lock()
+	queue, done := u.queue, u.done
+	u.queue, u.done = nil, nil
+	u.mu.Unlock()
+	if queue == nil {
+		return true
+	}
+
+	close(queue)
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// recordUsage adds a backend call to today's roll-ups.
+func (p *Plugin) recordUsage(rec kvstore.UsageRecord) {
+	day := time.Now().UTC().Format(usageDayFormat)
+	if !p.usage.add(day, rec) {
+		logError(p, errors.New("usage queue is full or stopped"), "cannot record usage", "user_id", rec.UserID)
+	}
+}
+
+// writeUsage stores a usage record in the KV store.
+func (p *Plugin) writeUsage(day string, rec kvstore.UsageRecord) {
+	if err := p.kvstore.RecordUsage(day, rec); err != nil {
+		logError(p, err, "cannot record usage")
+	}
+}
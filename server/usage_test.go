@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ghaffaria/mattermost-plugin-starter-template/server/store/kvstore"
+)
+
+func TestMeteredBackend(t *testing.T) {
+	var records []kvstore.UsageRecord
+	metered := func(b Backend) *meteredBackend {
+		return &meteredBackend{Backend: b, agent: "echo", record: func(rec kvstore.UsageRecord) {
+			records = append(records, rec)
+		}}
+	}
+	req := &AskRequest{
+		Query:   "why?",
+		UserID:  "alice",
+		History: []ChatMessage{{Role: roleUser, Author: "bob", Text: "the build failed"}},
+		Files:   []FileContext{{Name: "build.log", Text: "error: missing module"}},
+	}
+
+	t.Run("input includes history and files", func(t *testing.T) {
+		records = nil
+		stream, err := metered(NewEchoBackend()).Stream(context.Background(), req)
+		require.NoError(t, err)
+		text, err := io.ReadAll(stream)
+		require.NoError(t, err)
+		require.NoError(t, stream.Close())
+
+		require.Len(t, records, 1)
+		counters := records[0].Counters
+		assert.Equal(t, int64(1), counters.Requests)
+		assert.Zero(t, counters.Failures)
+		assert.Equal(t, int64(len(queryWithHistory(req))), counters.InputBytes)
+		assert.Greater(t, counters.InputBytes, int64(len(req.Query)+len("the build failed")+len("error: missing module")))
+		assert.Equal(t, int64(len(text)), counters.OutputBytes)
+	})
+
+	t.Run("calls that never reached the backend are not counted", func(t *testing.T) {
+		records = nil
+		breaker := &circuitBreaker{}
+		breaker.configure(1, time.Minute)
+		breaker.record(assert.AnError)
+		_, err := metered(&breakerBackend{Backend: NewEchoBackend(), breaker: breaker}).Stream(context.Background(), req)
+		assert.ErrorIs(t, err, errCircuitOpen)
+
+		limiter := &requestLimiter{}
+		limiter.setLimit(1)
+		limiter.drain(0)
+		_, err = metered(&limitedBackend{Backend: NewEchoBackend(), limiter: limiter}).Ask(context.Background(), req)
+		assert.ErrorIs(t, err, errDraining)
+
+		assert.Empty(t, records)
+	})
+}
+
+func TestUsageRecorder(t *testing.T) {
+	var u usageRecorder
+	assert.False(t, u.add("2024-05-01", kvstore.UsageRecord{}), "a stopped recorder refuses records")
+
+	var mu sync.Mutex
+	var written []string
+	release := make(chan struct{})
+	u.start(func(day string, rec kvstore.UsageRecord) {
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		written = append(written, rec.UserID)
+	})
+
+	// the queue is bounded
+	accepted := 0
+	for i := 0; i < usageQueueSize+10; i++ {
+		if u.add("2024-05-01", kvstore.UsageRecord{UserID: "alice"}) {
+			accepted++
+		}
+	}
+	assert.Less(t, accepted, usageQueueSize+10)
+
+	// stop waits for the queued records
+	close(release)
+	assert.True(t, u.stop(time.Second))
+	assert.Len(t, written, accepted)
+	assert.False(t, u.add("2024-05-01", kvstore.UsageRecord{}))
+}
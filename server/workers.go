@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkerCount = 4
+	defaultQueueSize   = 100
+)
+
+// errQueueFull is the outcome of a question rejected because the queue was full.
+var errQueueFull = errors.New("question queue is full")
+
+// workerPool runs jobs on a shared set of workers. Each channel has its own
+// FIFO queue, and at most one job of a channel runs at a time, so the jobs of
+// a channel run in the order they were submitted while a busy channel never
+// holds up the others. Channels with waiting jobs take turns.
+type workerPool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	workers   int // target number of workers
+	active    int // running worker goroutines
+	queueSize int // waiting jobs across all channels
+	wg        sync.WaitGroup
+
+	queues  map[string][]func() // waiting jobs of channels that are ready or running
+	ready   []string            // channels with a waiting job and none running
+	pending int
+	stopped bool
+
+	onPanic func(any)
+}
+
+// newWorkerPool starts workers goroutines. queueSize is the total number of
+// jobs that may wait, shared by all channels.
+func newWorkerPool(workers, queueSize int, onPanic func(any)) *workerPool {
+	wp := &workerPool{
+		queues:  map[string][]func(){},
+		onPanic: onPanic,
+	}
+	wp.cond = sync.NewCond(&wp.mu)
+	wp.resize(workers, queueSize)
+	return wp
+}
+
+// workerPoolSize applies the defaults to unset pool settings.
+func workerPoolSize(workers, queueSize int) (int, int) {
+	if workers <= 0 {
+		workers = defaultWorkerCount
+	}
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	return workers, queueSize
+}
+
+// resize changes the number of workers and the queue size in place. Queued
+// jobs are kept, so the order of each channel is preserved; surplus workers
+// exit after their current job.
+func (wp *workerPool) resize(workers, queueSize int) {
+	workers, queueSize = workerPoolSize(workers, queueSize)
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.workers, wp.queueSize = workers, queueSize
+	for ; !wp.stopped && wp.active < workers; wp.active++ {
+		wp.wg.Add(1)
+		go wp.work()
+	}
+	wp.cond.Broadcast()
+}
+
+func (wp *workerPool) work() {
+	defer wp.wg.Done()
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	for {
+		for len(wp.ready) == 0 && wp.active <= wp.workers && !(wp.stopped && wp.pending == 0) {
+			wp.cond.Wait()
+		}
+		if wp.active > wp.workers || len(wp.ready) == 0 {
+			// surplus after a resize, or stopped with nothing left to run
+			wp.active--
+			return
+		}
+
+		key := wp.ready[0]
+		wp.ready = wp.ready[1:]
+		job := wp.queues[key][0]
+		wp.queues[key] = wp.queues[key][1:]
+		wp.pending--
+
+		wp.mu.Unlock()
+		wp.run(job)
+		wp.mu.Lock()
+
+		if len(wp.queues[key]) > 0 {
+			wp.ready = append(wp.ready, key)
+		} else {
+			delete(wp.queues, key)
+		}
+		// wake a worker for the channel, or the others to exit once drained
+		wp.cond.Broadcast()
+	}
+}
+
+func (wp *workerPool) run(job func()) {
+	defer func() {
+		if r := recover(); r != nil && wp.onPanic != nil {
+			wp.onPanic(r)
+		}
+	}()
+	job()
+}
+
+// submit queues job for key. It returns false without blocking if the queue
+// is full or the pool has been stopped.
+func (wp *workerPool) submit(key string, job func()) bool {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	if wp.stopped || wp.pending >= wp.queueSize {
+		return false
+	}
+
+	queue, busy := wp.queues[key]
+	wp.queues[key] = append(queue, job)
+	wp.pending++
+	if !busy {
+		wp.ready = append(wp.ready, key)
+		wp.cond.Signal()
+	}
+	return true
+}
+
+// stop refuses new jobs and waits up to timeout for the queued ones to finish.
+// It reports whether the pool drained in time.
+func (wp *workerPool) stop(timeout time.Duration) bool {
+	wp.mu.Lock()
+	wp.stopped = true
+	wp.cond.Broadcast()
+	wp.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wp.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// applyWorkerConfiguration starts the worker pool, or resizes it in place so
+// that questions already queued keep their order.
+func (p *Plugin) applyWorkerConfiguration(cfg *Configuration) {
+	p.workersLock.Lock()
+	defer p.workersLock.Unlock()
+	if p.workers != nil {
+		p.workers.resize(cfg.WorkerCount, cfg.QueueSize)
+		return
+	}
+	p.workers = newWorkerPool(cfg.WorkerCount, cfg.QueueSize, func(r any) {
+		logError(p, fmt.Errorf("panic in worker: %v", r))
+	})
+}
+
+// submitJob queues job for the channel. It returns false when the plugin is too
+// busy to accept it.
+func (p *Plugin) submitJob(channelID string, job func()) bool {
+	p.workersLock.RLock()
+	defer p.workersLock.RUnlock()
+	if p.workers == nil {
+		return false
+	}
+	return p.workers.submit(channelID, job)
+}
+
+// stopWorkers drains the worker pool on deactivation.
+func (p *Plugin) stopWorkers() {
+	p.workersLock.Lock()
+	workers := p.workers
+	p.workers = nil
+	p.workersLock.Unlock()
+
+	if workers != nil && !workers.stop(drainTimeout) {
+		p.API.LogWarn("Queued questions still running at deactivation", "timeout", drainTimeout.String())
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPoolOrderingAndBackpressure(t *testing.T) {
+	wp := newWorkerPool(1, 2, nil)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var mu sync.Mutex
+	var order []int
+
+	// the first job blocks the only worker; two more fill the queue
+	assert.True(t, wp.submit("channel", func() {
+		close(started)
+		<-release
+	}))
+	<-started
+	for i := 1; i <= 2; i++ {
+		i := i
+		assert.True(t, wp.submit("channel", func() {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, i)
+		}))
+	}
+	assert.False(t, wp.submit("channel", func() {}), "queue should be full")
+
+	close(release)
+	assert.True(t, wp.stop(time.Second))
+	assert.Equal(t, []int{1, 2}, order)
+	assert.False(t, wp.submit("channel", func() {}), "stopped pool must refuse jobs")
+}
+
+func TestWorkerPoolRecoversFromPanics(t *testing.T) {
+	var recovered any
+	wp := newWorkerPool(1, 1, func(r any) { recovered = r })
+
+	wp.submit("channel", func() { panic("boom") })
+	assert.True(t, wp.stop(time.Second))
+	assert.Equal(t, "boom", recovered)
+}
+
+func TestWorkerPoolBusyChannelDoesNotBlockOthers(t *testing.T) {
+	wp := newWorkerPool(2, 10, nil)
+	defer wp.stop(time.Second)
+
+	// one channel's slow question and the next one waiting behind it
+	release := make(chan struct{})
+	started := make(chan struct{})
+	assert.True(t, wp.submit("busy", func() {
+		close(started)
+		<-release
+	}))
+	<-started
+	assert.True(t, wp.submit("busy", func() {}))
+
+	// another channel is answered meanwhile, whatever worker it would hash to
+	done := make(chan struct{})
+	assert.True(t, wp.submit("other", func() { close(done) }))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("job of an idle channel waited behind a busy channel")
+	}
+	close(release)
+}
+
+func TestWorkerPoolResizeKeepsOrder(t *testing.T) {
+	wp := newWorkerPool(1, 10, nil)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []int
+	assert.True(t, wp.submit("channel", func() { <-release }))
+	for i := 1; i <= 3; i++ {
+		i := i
+		assert.True(t, wp.submit("channel", func() {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, i)
+		}))
+	}
+
+	// more workers must not run the queued jobs of a channel concurrently
+	wp.resize(4, 2)
+	assert.False(t, wp.submit("channel", func() {}), "queue shrank below the waiting jobs")
+
+	close(release)
+	assert.True(t, wp.stop(time.Second))
+	assert.Equal(t, []int{1, 2, 3}, order)
+}